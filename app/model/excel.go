@@ -14,10 +14,16 @@ type EventLogSetting struct {
 	//对应的日志下标
 	CsvIndex byte
 
+	//对应的日志列名(json,kv格式日志)
+	Column string
+
+	//计算字段值的表达式,如concat($3,"_",$4),配置后忽视日志下标
+	Expression string
+
 	//对应的java类型
 	DataType string
 
-	//对应的数数处理的type类型
+	//对应的数数处理的type类型(track,track_update,track_overwrite,user_set,user_setOnce,user_add,user_append,user_unset,user_del)
 	SsType string
 
 	//事件名
@@ -31,8 +37,119 @@ type EventLogSetting struct {
 
 	//日志类型
 	LogType string
+
+	//日志行解码器(tsv,csv,delim(|),json,kv),为空时使用日志类型默认解码器
+	Decoder string
+
+	//过滤表达式,结果为true时上报,如$13 == "shop" && !blacklisted($5);公共配置的过滤规则对所有事件生效
+	Filter string
 }
 
 func (that *EventLogSetting) Identity() string {
 	return that.RecordName + "_" + that.SsType + "_" + that.EventName
 }
+
+/**
+excel 映射表配置类,用于enum类型字段,如道具id对应道具名
+*/
+type EnumSetting struct {
+
+	// 无意义id
+	Id int
+
+	// 映射名
+	Enum string
+
+	// 日志中的值
+	Key string
+
+	// 上报的值
+	Value string
+}
+
+/**
+excel 项目规则配置类,用于不同项目的字段覆盖和账号规则
+*/
+type ProjectRule struct {
+
+	// 规则id,同类规则按照id顺序执行
+	Id int
+
+	// 规则类型(override:覆盖字段原始值,account_id:转换#account_id,distinct_id:生成#distinct_id,property:生成属性)
+	Kind string
+
+	// 目标字段名(override,property使用)
+	Target string
+
+	// 计算表达式,结果为空时规则不生效
+	Expression string
+
+	// 生效的上报类型,逗号分隔,为空时全部生效
+	UploadType string
+}
+
+/**
+excel 上报路由配置类,按照id顺序匹配,都不匹配时上报到HttpServerUrl
+*/
+type UploadRoute struct {
+
+	// 路由id
+	Id int
+
+	// 上报目标名称,用于日志和监控
+	Name string
+
+	// 运营商,多个逗号分隔,为空匹配所有
+	Operator string
+
+	// 服务器范围,如1-100,200,为空匹配所有
+	Server string
+
+	// 事件名,多个逗号分隔,为空匹配所有
+	EventName string
+
+	// 数数上报url
+	Url string
+
+	// 数数上报appid
+	AppId string
+
+	// 认证token,设置Authorization头
+	Token string
+
+	// 最大重试次数,默认5
+	RetryTimes int
+
+	// 重试间隔,默认2s
+	RetryInterval string
+
+	// 上报内容压缩方式(none,gzip,gzip(1-9),zstd,snappy,lz4),为空时使用输出目标的配置
+	Compress string
+}
+
+// 输出目标配置,和默认输出目标同时上报,独立记录断点
+type SinkSetting struct {
+	// 输出目标id
+	Id int
+
+	// 输出目标名称,作为断点id后缀,只能包含字母,数字,_和-
+	Name string
+
+	// 输出类型console,http
+	PushType string
+
+	// 数数上报url
+	HttpServerUrl string
+
+	// 数数上报appid
+	HttpAppId string
+
+	// Excel上报路由配置路径
+	RoutePath string
+
+	// 开始上报日志的时间,为空时使用StartDay
+	StartDay string
+
+	// 上报内容压缩方式,为空时使用HttpCompress
+	HttpCompress string
+}
@@ -0,0 +1,153 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+	"xai.com/shushu/app/model"
+)
+
+// 延迟写入的断点，同一任务只保留最新的断点，按照间隔在一个事务中批量写入
+var checkpoints = newCheckpointWriter()
+
+type checkpointWriter struct {
+	lock     sync.Mutex
+	enabled  bool
+	pending  map[string]logPosition // 断点id -> 最新断点
+	ledgers  map[string]LedgerEntry // 上报记录id -> 最新记录
+	interval time.Duration
+}
+
+func newCheckpointWriter() *checkpointWriter {
+	return &checkpointWriter{pending: make(map[string]logPosition), ledgers: make(map[string]LedgerEntry)}
+}
+
+// 初始化断点写入方式，CheckpointFlushInterval为空或者0时每批次同步写入
+func InitCheckpoint(ctx context.Context, config *model.AppConfig) {
+	if len(config.CheckpointFlushInterval) == 0 {
+		return
+	}
+	interval, err := time.ParseDuration(config.CheckpointFlushInterval)
+	if err != nil || interval < 0 {
+		panic("CheckpointFlushInterval配置错误" + config.CheckpointFlushInterval)
+	}
+	if interval == 0 {
+		return
+	}
+	checkpoints.lock.Lock()
+	checkpoints.enabled = true
+	checkpoints.interval = interval
+	checkpoints.lock.Unlock()
+	log.Println("断点延迟写入,写入间隔", interval)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := checkpoints.flush(ctx); err != nil {
+					log.Println("批量保存断点失败,等待下次重试", err)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// 保存断点和当前文件的上报记录，开启延迟写入时只记录最新断点
+func saveCheckpoint(ctx context.Context, position *logPosition, entry *LedgerEntry) error {
+	if checkpoints.save(position, entry) {
+		return nil
+	}
+	if err := SaveToDb(ctx, position); err != nil {
+		return err
+	}
+	if entry != nil {
+		return upsertLedger(ctx, ledgerStmt, entry)
+	}
+	return nil
+}
+
+func (w *checkpointWriter) save(position *logPosition, entry *LedgerEntry) bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if !w.enabled {
+		return false
+	}
+	w.pending[position.Id] = *position
+	if entry != nil {
+		w.ledgers[entry.Id] = entry.clone()
+	}
+	return true
+}
+
+// 在一个事务中写入所有待写入的断点，失败时保留没有被更新的断点等待下次写入
+func (w *checkpointWriter) flush(ctx context.Context) error {
+	pending, ledgers := w.take()
+	if len(pending) == 0 && len(ledgers) == 0 {
+		return nil
+	}
+	err := writeCheckpoints(ctx, pending, ledgers)
+	if err != nil {
+		w.lock.Lock()
+		for id, position := range pending {
+			if _, ok := w.pending[id]; !ok {
+				w.pending[id] = position
+			}
+		}
+		for id, entry := range ledgers {
+			if _, ok := w.ledgers[id]; !ok {
+				w.ledgers[id] = entry
+			}
+		}
+		w.lock.Unlock()
+		return err
+	}
+	log.Println("批量保存断点数量", len(pending))
+	return nil
+}
+
+// 取出所有待写入的断点和上报记录
+func (w *checkpointWriter) take() (map[string]logPosition, map[string]LedgerEntry) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	pending, ledgers := w.pending, w.ledgers
+	w.pending = make(map[string]logPosition, len(pending))
+	w.ledgers = make(map[string]LedgerEntry, len(ledgers))
+	return pending, ledgers
+}
+
+func writeCheckpoints(ctx context.Context, positions map[string]logPosition, ledgers map[string]LedgerEntry) error {
+	if dbPool == nil {
+		panic("数据库未完成初始化")
+	}
+	tx, err := dbPool.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("开启事务失败%s", err.Error())
+	}
+	stmt := tx.StmtContext(ctx, upsertStmt)
+	for _, position := range positions {
+		position := position
+		if err := upsertPosition(ctx, stmt, &position); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	if len(ledgers) > 0 {
+		stmt = tx.StmtContext(ctx, ledgerStmt)
+		for _, entry := range ledgers {
+			entry := entry
+			if err := upsertLedger(ctx, stmt, &entry); err != nil {
+				_ = tx.Rollback()
+				return err
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("提交事务失败%s", err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// 记录执行语句的数据库驱动
+type recordDriver struct {
+	lock    sync.Mutex
+	execs   [][]driver.Value
+	commits int
+	fail    bool
+}
+
+func (d *recordDriver) Open(name string) (driver.Conn, error) {
+	return &recordConn{driver: d}, nil
+}
+
+type recordConn struct {
+	driver *recordDriver
+}
+
+func (c *recordConn) Prepare(query string) (driver.Stmt, error) {
+	return &recordStmt{driver: c.driver}, nil
+}
+
+func (c *recordConn) Close() error {
+	return nil
+}
+
+func (c *recordConn) Begin() (driver.Tx, error) {
+	return &recordTx{driver: c.driver}, nil
+}
+
+type recordTx struct {
+	driver *recordDriver
+}
+
+func (t *recordTx) Commit() error {
+	t.driver.lock.Lock()
+	defer t.driver.lock.Unlock()
+	t.driver.commits++
+	return nil
+}
+
+func (t *recordTx) Rollback() error {
+	return nil
+}
+
+type recordStmt struct {
+	driver *recordDriver
+}
+
+func (s *recordStmt) Close() error {
+	return nil
+}
+
+func (s *recordStmt) NumInput() int {
+	return -1
+}
+
+func (s *recordStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.driver.lock.Lock()
+	defer s.driver.lock.Unlock()
+	if s.driver.fail {
+		return nil, errors.New("database down")
+	}
+	s.driver.execs = append(s.driver.execs, args)
+	return driver.RowsAffected(1), nil
+}
+
+func (s *recordStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return &emptyRows{}, nil
+}
+
+type emptyRows struct{}
+
+func (r *emptyRows) Columns() []string {
+	return []string{"id"}
+}
+
+func (r *emptyRows) Close() error {
+	return nil
+}
+
+func (r *emptyRows) Next(dest []driver.Value) error {
+	return io.EOF
+}
+
+// 已经注册的记录驱动数量，驱动名称不能重复注册
+var recordDrivers int32
+
+// 使用记录驱动作为断点数据库
+func useRecordDatabase(t *testing.T) *recordDriver {
+	recorder := &recordDriver{}
+	name := "record_" + t.Name() + "_" + strconv.Itoa(int(atomic.AddInt32(&recordDrivers, 1)))
+	sql.Register(name, recorder)
+	db, err := sql.Open(name, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbPool = db
+	dbDialect = dialects["mysql"]
+	prepareStatements()
+	return recorder
+}
+
+func TestCheckpointWriteBehind(t *testing.T) {
+	recorder := useRecordDatabase(t)
+	writer := newCheckpointWriter()
+	writer.enabled = true
+	checkpoints = writer
+	defer func() {
+		dbPool = nil
+		checkpoints = newCheckpointWriter()
+	}()
+
+	position := &logPosition{Id: "1_1_charge_record"}
+	for i := 1; i <= 10; i++ {
+		position.Position = int64(i * 100)
+		if err := saveCheckpoint(context.Background(), position, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := saveCheckpoint(context.Background(), &logPosition{Id: "1_2_charge_record", Position: 5}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(recorder.execs) != 0 {
+		t.Fatal("延迟写入时不应该立即写入数据库")
+	}
+	if err := writer.flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(recorder.execs) != 2 || recorder.commits != 1 {
+		t.Fatal("同一任务应该合并为一次写入并在一个事务中提交", len(recorder.execs), recorder.commits)
+	}
+	for _, args := range recorder.execs {
+		if args[0] == "1_1_charge_record" && args[6] != int64(1000) {
+			t.Fatal("应该写入最新断点", args)
+		}
+	}
+
+	// 写入失败时保留断点等待下次写入
+	recorder.fail = true
+	_ = saveCheckpoint(context.Background(), position, nil)
+	if err := writer.flush(context.Background()); err == nil {
+		t.Fatal("写入失败应该返回错误")
+	}
+	if len(writer.pending) != 1 {
+		t.Fatal("写入失败的断点应该保留", writer.pending)
+	}
+}
+
+func TestCheckpointSync(t *testing.T) {
+	recorder := useRecordDatabase(t)
+	defer func() { dbPool = nil }()
+	if err := saveCheckpoint(context.Background(), &logPosition{Id: "1_1_charge_record", Position: 10}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(recorder.execs) != 1 || recorder.commits != 0 {
+		t.Fatal("没有开启延迟写入时应该同步写入", len(recorder.execs))
+	}
+}
@@ -0,0 +1,158 @@
+package service
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 默认压缩方式，与之前的上报方式相同
+const defaultCompression = "gzip"
+
+// 可以复用的压缩writer
+type compressWriter interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+}
+
+// 上报内容压缩方式，通过compress请求头告诉接收端
+type compressor struct {
+	spec    string
+	name    string // none,gzip,zstd,snappy,lz4
+	writers sync.Pool
+}
+
+func (c *compressor) String() string {
+	return c.spec
+}
+
+// 获取复用的writer，写入结束后调用release归还
+func (c *compressor) writer(w io.Writer) compressWriter {
+	writer := c.writers.Get().(compressWriter)
+	writer.Reset(w)
+	return writer
+}
+
+func (c *compressor) release(writer compressWriter) {
+	c.writers.Put(writer)
+}
+
+var compressorCache sync.Map
+
+// 根据配置获取压缩方式，支持 none, gzip, gzip(1-9), zstd, zstd(1-22), snappy, lz4, lz4(1-9)
+func newCompressor(spec string) (*compressor, error) {
+	spec = strings.TrimSpace(spec)
+	if len(spec) == 0 {
+		spec = defaultCompression
+	}
+	if c, ok := compressorCache.Load(spec); ok {
+		return c.(*compressor), nil
+	}
+	name, args := spec, ""
+	if start := strings.Index(spec, "("); start > 0 && strings.HasSuffix(spec, ")") {
+		name, args = spec[:start], spec[start+1:len(spec)-1]
+	}
+	level, hasLevel := 0, len(args) > 0
+	if hasLevel {
+		var err error
+		if level, err = strconv.Atoi(strings.TrimSpace(args)); err != nil {
+			return nil, fmt.Errorf("压缩级别错误[%s]", spec)
+		}
+	}
+	c := &compressor{spec: spec, name: name}
+	switch name {
+	case "none":
+		if hasLevel {
+			return nil, fmt.Errorf("%s不支持压缩级别", name)
+		}
+		c.writers.New = func() interface{} { return &plainWriter{} }
+	case "gzip":
+		if !hasLevel {
+			level = gzip.DefaultCompression
+		} else if level < gzip.BestSpeed || level > gzip.BestCompression {
+			return nil, fmt.Errorf("gzip压缩级别为1-9[%s]", spec)
+		}
+		c.writers.New = func() interface{} {
+			writer, _ := gzip.NewWriterLevel(nil, level)
+			return writer
+		}
+	case "zstd":
+		encoderLevel := zstd.SpeedDefault
+		if hasLevel {
+			if level < 1 || level > 22 {
+				return nil, fmt.Errorf("zstd压缩级别为1-22[%s]", spec)
+			}
+			encoderLevel = zstd.EncoderLevelFromZstd(level)
+		}
+		// 每个writer只使用一个协程，并发由上报协程控制
+		c.writers.New = func() interface{} {
+			writer, _ := zstd.NewWriter(nil, zstd.WithEncoderLevel(encoderLevel), zstd.WithEncoderConcurrency(1))
+			return writer
+		}
+	case "snappy":
+		if hasLevel {
+			return nil, fmt.Errorf("%s不支持压缩级别", name)
+		}
+		c.writers.New = func() interface{} { return snappy.NewBufferedWriter(nil) }
+	case "lz4":
+		option := lz4.CompressionLevelOption(lz4.Fast)
+		if hasLevel {
+			if level < 1 || level > 9 {
+				return nil, fmt.Errorf("lz4压缩级别为1-9[%s]", spec)
+			}
+			option = lz4.CompressionLevelOption(lz4.CompressionLevel(1 << (8 + level)))
+		}
+		if err := lz4.NewWriter(nil).Apply(option); err != nil {
+			return nil, err
+		}
+		// Reset保留压缩级别
+		c.writers.New = func() interface{} {
+			writer := lz4.NewWriter(nil)
+			_ = writer.Apply(option)
+			return writer
+		}
+	default:
+		return nil, fmt.Errorf("不支持的压缩方式[%s]", spec)
+	}
+	actual, _ := compressorCache.LoadOrStore(spec, c)
+	return actual.(*compressor), nil
+}
+
+// 上报目标的压缩方式，没有配置时使用默认压缩方式
+func compressorOrDefault(c *compressor) *compressor {
+	if c != nil {
+		return c
+	}
+	c, err := newCompressor(defaultCompression)
+	if err != nil {
+		panic(err.Error())
+	}
+	return c
+}
+
+// 不压缩
+type plainWriter struct {
+	writer io.Writer
+}
+
+func (w *plainWriter) Write(p []byte) (int, error) {
+	if w.writer == nil {
+		return 0, errors.New("writer已经关闭")
+	}
+	return w.writer.Write(p)
+}
+
+func (w *plainWriter) Close() error {
+	return nil
+}
+
+func (w *plainWriter) Reset(writer io.Writer) {
+	w.writer = writer
+}
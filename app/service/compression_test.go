@@ -0,0 +1,164 @@
+package service
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"xai.com/shushu/app/model"
+)
+
+// 按照压缩方式解压
+func decompress(t testing.TB, name string, content []byte) []byte {
+	var reader io.Reader
+	switch name {
+	case "none":
+		return content
+	case "gzip":
+		gz, err := gzip.NewReader(bytes.NewReader(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = gz
+	case "zstd":
+		decoder, err := zstd.NewReader(bytes.NewReader(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer decoder.Close()
+		reader = decoder
+	case "snappy":
+		reader = snappy.NewReader(bytes.NewReader(content))
+	case "lz4":
+		reader = lz4.NewReader(bytes.NewReader(content))
+	}
+	result, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(name, err)
+	}
+	return result
+}
+
+// 使用测试日志生成一批上报数据
+func tlogRows(t testing.TB, count int) []*uploadRow {
+	content, err := ioutil.ReadFile("test/SmallFile.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	rows := make([]*uploadRow, 0, count)
+	for i := 0; len(rows) < count; i++ {
+		cols := strings.Split(lines[i%len(lines)], "\t")
+		row := newUploadRow(5, len(cols))
+		row.fields.set("#type", "track")
+		row.fields.set("#event_name", "login_record")
+		row.fields.set("#account_id", cols[4])
+		row.fields.set("#time", time.Unix(1622626947, 0).Add(time.Duration(i)*time.Millisecond*37).Format("2006-01-02 15:04:05.000"))
+		row.fields.set("#zone_offset", 8.0)
+		for c, col := range cols {
+			if value, err := strconv.ParseInt(col, 10, 64); err == nil {
+				row.properties.set("col"+strconv.Itoa(c+1), value+int64(i/len(lines)*7919%100003))
+			} else {
+				row.properties.set("col"+strconv.Itoa(c+1), col)
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func TestCompressors(t *testing.T) {
+	rows := tlogRows(t, 200)
+	for _, spec := range []string{"", "none", "gzip(1)", "gzip(9)", "zstd", "zstd(19)", "snappy", "lz4", "lz4(9)"} {
+		c, err := newCompressor(spec)
+		if err != nil {
+			t.Fatal(spec, err)
+		}
+		// 复用writer时结果相同
+		for i := 0; i < 2; i++ {
+			body, err := encodeRows(rows, c)
+			if err != nil {
+				t.Fatal(spec, err)
+			}
+			var decoded []map[string]interface{}
+			content := decompress(t, body.compression, body.Bytes())
+			if err := json.Unmarshal(content, &decoded); err != nil || len(decoded) != len(rows) || len(content) != body.size {
+				t.Fatal(spec, "解压结果错误", err, len(decoded))
+			}
+			body.release()
+		}
+	}
+	for _, spec := range []string{"gzip(0)", "gzip(x)", "zstd(23)", "snappy(1)", "none(1)", "lz4(10)", "brotli"} {
+		if _, err := newCompressor(spec); err == nil {
+			t.Fatal(spec, "应该校验失败")
+		}
+	}
+}
+
+func TestRouteCompress(t *testing.T) {
+	route, err := newUploadRoute(&model.UploadRoute{Id: 1, Url: "http://a", AppId: "a", Compress: "zstd"})
+	if err != nil || route.destination.compressor.name != "zstd" {
+		t.Fatal("路由压缩方式错误", err)
+	}
+	if _, err := newUploadRoute(&model.UploadRoute{Id: 2, Url: "http://a", AppId: "a", Compress: "zip"}); err == nil {
+		t.Fatal("不支持的压缩方式应该校验失败")
+	}
+	target := newRouter(defaultSinkName, "http://a", "a", "lz4", "")
+	if target.defaultDestination.compressor.name != "lz4" {
+		t.Fatal("默认上报目标压缩方式错误")
+	}
+
+	var received []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content, _ := ioutil.ReadAll(r.Body)
+		_ = json.Unmarshal(decompress(t, r.Header.Get("compress"), content), &received)
+		_, _ = w.Write([]byte(`{"code":0}`))
+	}))
+	defer server.Close()
+	httpClient = server.Client()
+	for _, spec := range []string{"none", "snappy"} {
+		c, _ := newCompressor(spec)
+		dest := &destination{name: "compress", url: server.URL, appId: "a", retryTimes: 1, compressor: c}
+		received = nil
+		if err := uploadRows(context.Background(), dest, tlogRows(t, 3)); err != nil || len(received) != 3 {
+			t.Fatal(spec, "上报失败", err, received)
+		}
+	}
+}
+
+// 一批真实tlog数据在不同压缩方式下的耗时和压缩率
+func BenchmarkCompression(b *testing.B) {
+	rows := tlogRows(b, 1000)
+	for _, spec := range []string{"none", "gzip(1)", "gzip", "gzip(9)", "zstd(1)", "zstd", "zstd(19)", "snappy", "lz4", "lz4(9)"} {
+		c, err := newCompressor(spec)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(spec, func(b *testing.B) {
+			b.ReportAllocs()
+			var size, compressed int
+			for i := 0; i < b.N; i++ {
+				body, err := encodeRows(rows, c)
+				if err != nil {
+					b.Fatal(err)
+				}
+				size, compressed = body.size, len(body.Bytes())
+				body.release()
+			}
+			b.SetBytes(int64(size))
+			b.ReportMetric(float64(compressed)/float64(size)*100, "%size")
+			b.ReportMetric(float64(compressed), "bytes")
+		})
+	}
+}
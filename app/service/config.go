@@ -2,11 +2,14 @@ package service
 
 import (
 	"bufio"
+	"errors"
+	"fmt"
 	"github.com/mitchellh/mapstructure"
 	"io"
 	"log"
 	"os"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"xai.com/shushu/app/model"
@@ -57,16 +60,26 @@ func LoadAppConfig(path string) *model.AppConfig {
 }
 
 // 加载Excel事件类型配置
-func LoadConfig(path string) map[string]*model.EventConfig {
+func LoadConfig(appConfig *model.AppConfig) map[string]*model.EventConfig {
+	lineDecoders := parseLineDecoders(appConfig.LineDecoder)
+	enums := loadEnums(appConfig.EnumPath)
 	settingStorage := NewStorage(reflect.TypeOf(model.EventLogSetting{}))
-	settingStorage.Load(path)
+	settingStorage.Load(appConfig.ExcelPath)
 	commonItems := settingStorage.GetIndex(true)
 	commonFields := make(map[string]*model.Field)
 	commonSystemFields := make(map[string]*model.Field)
+	commonFilters := make([]*model.Filter, 0)
 	for _, item := range commonItems {
 		setting := item.(*model.EventLogSetting)
+		if len(setting.Filter) > 0 {
+			commonFilters = append(commonFilters, &model.Filter{Id: setting.Id, Expression: setting.Filter})
+		}
+		// 只配置了过滤规则的公共行
+		if len(setting.Name) == 0 {
+			continue
+		}
 		name := setting.Name
-		value := &model.Field{Index: setting.CsvIndex, DataType: setting.DataType}
+		value := &model.Field{Index: setting.CsvIndex, Column: setting.Column, Expression: setting.Expression, DataType: setting.DataType}
 		commonFields[name] = value
 		if strings.HasPrefix(name, "#") {
 			commonSystemFields[name] = value
@@ -93,11 +106,19 @@ func LoadConfig(path string) map[string]*model.EventConfig {
 					fields[k] = v
 				}
 			}
-			config = &model.EventConfig{Fields: fields}
+			filters := make([]*model.Filter, 0, len(commonFilters))
+			filters = append(filters, commonFilters...)
+			config = &model.EventConfig{Fields: fields, Filters: filters}
 			eventConfigs[identity] = config
 		}
 		//设置当前属性
-		config.PutField(setting.Name, setting.CsvIndex, setting.DataType)
+		if len(setting.Name) > 0 {
+			config.PutField(setting.Name, setting.CsvIndex, setting.Column, setting.Expression, setting.DataType)
+		}
+		//设置过滤规则
+		if len(setting.Filter) > 0 {
+			config.Filters = append(config.Filters, &model.Filter{Id: setting.Id, Expression: setting.Filter})
+		}
 		//日志类型,对应游戏服日志类型如ItemRecord
 		config.RecordName = setting.RecordName
 		//设置当前事件名
@@ -106,10 +127,103 @@ func LoadConfig(path string) map[string]*model.EventConfig {
 		config.FileType = setting.LogType
 		//设置数数后台处理类型
 		config.UploadType = setting.SsType
+		//设置日志行解码器
+		decoder := setting.Decoder
+		if len(decoder) == 0 {
+			decoder = lineDecoders[setting.LogType]
+		}
+		if len(config.Decoder) > 0 && config.Decoder != decoder {
+			panic("Excel事件配置错误" + identity + ":同一事件配置了不同的解码器" + config.Decoder + "," + decoder)
+		}
+		config.Decoder = decoder
+	}
+	decoderByRecord := make(map[string]string)
+	for identity, config := range eventConfigs {
+		// 同一日志文件只能使用一个解码器
+		if pre, ok := decoderByRecord[config.RecordName]; ok && pre != config.Decoder {
+			panic("Excel事件配置错误" + identity + ":日志" + config.RecordName + "配置了不同的解码器" + pre + "," + config.Decoder)
+		}
+		decoderByRecord[config.RecordName] = config.Decoder
+	}
+	for identity, config := range eventConfigs {
+		sort.Slice(config.Filters, func(i, j int) bool { return config.Filters[i].Id < config.Filters[j].Id })
+		for name, field := range config.Fields {
+			if err := compileFieldType(field, enums); err != nil {
+				panic("Excel事件配置错误" + identity + ":字段" + name + ":" + err.Error())
+			}
+		}
+		if err := validateEventConfig(config); err != nil {
+			panic("Excel事件配置错误" + identity + ":" + err.Error())
+		}
 	}
 	return eventConfigs
 }
 
+// 按照上报类型校验事件配置的必填字段
+func validateEventConfig(config *model.EventConfig) error {
+	uploadType := config.UploadType
+	if !model.IsTrackType(uploadType) && !model.IsUserType(uploadType) {
+		return fmt.Errorf("不支持的上报类型[%s]", uploadType)
+	}
+	if config.Fields["#time"] == nil {
+		return errors.New("缺少#time字段")
+	}
+	if !isDateKind(config.Fields["#time"].Kind) {
+		return errors.New("#time字段必须是日期类型")
+	}
+	if config.Fields["#account_id"] == nil && config.Fields["#distinct_id"] == nil {
+		return errors.New("#account_id和#distinct_id至少配置一个")
+	}
+	for _, filter := range config.Filters {
+		if _, err := compileExpression(filter.Expression); err != nil {
+			return fmt.Errorf("过滤规则[%d]%s", filter.Id, err.Error())
+		}
+	}
+	decoder, err := NewLineDecoder(config.Decoder)
+	if err != nil {
+		return err
+	}
+	for name, field := range config.Fields {
+		if len(field.Expression) > 0 {
+			if _, err := compileExpression(field.Expression); err != nil {
+				return fmt.Errorf("字段[%s]%s", name, err.Error())
+			}
+			continue
+		}
+		if decoder.Named() && len(field.Column) == 0 {
+			return fmt.Errorf("解码器[%s]需要配置字段[%s]的列名", config.Decoder, name)
+		}
+		if !decoder.Named() && field.Index == 0 {
+			return fmt.Errorf("字段[%s]没有配置日志下标", name)
+		}
+	}
+	if model.IsTrackType(uploadType) && len(config.Name) == 0 {
+		return fmt.Errorf("%s类型必须配置事件名", uploadType)
+	}
+	if (uploadType == model.UploadTrackUpdate || uploadType == model.UploadTrackOverwrite) && config.Fields["#event_id"] == nil {
+		return fmt.Errorf("%s类型必须配置#event_id字段", uploadType)
+	}
+	if uploadType != model.UploadTrack && config.Fields["#first_check_id"] != nil {
+		return errors.New("#first_check_id只能用于track类型")
+	}
+	for name, field := range config.Fields {
+		if strings.HasPrefix(name, "#") {
+			continue
+		}
+		switch uploadType {
+		case model.UploadUserAdd:
+			if !isNumberKind(field.Kind) {
+				return fmt.Errorf("user_add属性[%s]必须是数值类型,当前为[%s]", name, field.DataType)
+			}
+		case model.UploadUserAppend:
+			if !isListKind(field.Kind) {
+				return fmt.Errorf("user_append属性[%s]必须是列表类型,当前为[%s]", name, field.DataType)
+			}
+		}
+	}
+	return nil
+}
+
 // 加载serverlist配置
 func LoadServerConfig(path string) map[string]*model.ServerConfig {
 	config := make(map[string]*model.ServerConfig)
@@ -141,7 +255,15 @@ func LoadServerConfig(path string) map[string]*model.ServerConfig {
 		operator, err := strconv.Atoi(fields[0])
 		server, err := strconv.Atoi(fields[1])
 
-		config[fields[0]+fields[1]] = &model.ServerConfig{Operator: operator, Server: server, Port: fields[2]}
+		serverConfig := &model.ServerConfig{Operator: operator, Server: server, Port: fields[2]}
+		// 第四列为服务器时区
+		if len(fields) > 3 {
+			if _, err := loadLocation(fields[3]); err != nil {
+				log.Panic(path, " [", s, "] 时区配置错误[", fields[3], "]:", err)
+			}
+			serverConfig.Timezone = fields[3]
+		}
+		config[fields[0]+fields[1]] = serverConfig
 	}
 	return config
 }
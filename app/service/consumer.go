@@ -2,141 +2,297 @@ package service
 
 import (
 	"bytes"
-	"compress/gzip"
+	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"reflect"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 	"xai.com/shushu/app/model"
 )
 
 var (
-	processor        []func(string, []string, []*model.EventConfig)
 	httpClient       *http.Client
-	uploadUrl        string
-	uploadAppId      string
 	ignoreFieldError bool
 )
 
 func InitConsumer(config *model.AppConfig) {
 	ignoreFieldError, _ = strconv.ParseBool(config.IgnoreFieldError)
-	processor = make([]func(string, []string, []*model.EventConfig), 0, 2)
-	if strings.Contains(config.PushType, "console") {
-		processor = append(processor, consoleProcess)
-	}
-	if strings.Contains(config.PushType, "http") {
-		processor = append(processor, httpProcess)
-		httpClient = &http.Client{
-			Transport: &http.Transport{
-				MaxIdleConnsPerHost: 10,
-				MaxConnsPerHost:     10,
-				IdleConnTimeout:     time.Minute,
-			},
-			Timeout: time.Duration(3) * time.Second,
+	LoadAccountBlacklist(config.AccountBlacklist)
+	initDeadLetter(config.DeadLetterPath)
+	initTimePolicy(config)
+	initUploadFailurePolicy(config)
+	LoadProjectRules(config.ProjectRulePath)
+	initSourceMetadata(config.SourceMetadata)
+	client, err := newHttpClient(config)
+	if err != nil {
+		panic("http上报配置错误:" + err.Error())
+	}
+	httpClient = client
+	httpHeaders, err = parseHttpHeaders(config.HttpHeaders)
+	if err != nil {
+		panic(err.Error())
+	}
+	initSinks(config)
+	if check, _ := strconv.ParseBool(config.HttpCheck); check {
+		if err := checkAllDestinations(context.Background()); err != nil {
+			panic(err.Error())
 		}
-		uploadUrl = config.HttpServerUrl
-		uploadAppId = config.HttpAppId
 	}
 }
 
-func Process(recordName string, lines []string, eventConfigs []*model.EventConfig) {
-	for _, process := range processor {
-		process(recordName, lines, eventConfigs)
+// 按照日志来源的输出目标处理，返回错误时当前批次没有处理完成，不能保存断点。
+// ctx取消时放弃正在进行的上报
+func Process(ctx context.Context, source *LogSource, lines []string, eventConfigs []*model.EventConfig) error {
+	target, ok := sinks[source.Sink]
+	if !ok {
+		return fmt.Errorf("输出目标[%s]不存在", source.Sink)
+	}
+	for _, process := range target.processors {
+		if err := process(ctx, target, source, lines, eventConfigs); err != nil {
+			return err
+		}
+	}
+	// 只有控制台输出时每一行都认为已经上报
+	if target.router == nil {
+		source.Stats.acked(len(lines))
 	}
+	return nil
 }
 
 // 标准输出
-func consoleProcess(recordName string, lines []string, eventConfigs []*model.EventConfig) {
+func consoleProcess(ctx context.Context, target *sink, source *LogSource, lines []string, eventConfigs []*model.EventConfig) error {
 	for _, line := range lines {
-		fmt.Println(recordName, line)
+		fmt.Println(source.RecordName, line)
 	}
+	return nil
 }
 
 // HTTP上报
-func httpProcess(recordName string, lines []string, eventConfigs []*model.EventConfig) {
+func httpProcess(ctx context.Context, target *sink, source *LogSource, lines []string, eventConfigs []*model.EventConfig) error {
 	if lines == nil {
-		return
+		return nil
 	}
 	linesSize := len(lines)
 	if linesSize == 0 {
-		return
+		return nil
 	}
-	lineSplits := splitLines(lines)
-LOOP:
+	if len(eventConfigs) == 0 {
+		source.Stats.filtered(linesSize)
+		return nil
+	}
+	// 同一日志的事件使用相同的解码器
+	lineSplits := decodeLines(eventConfigs[0].Decoder, lines, source.Offsets)
+	source.Stats.dropped(linesSize - len(lineSplits))
+	// 每一行的处理结果，任意一个目标接收即为上报，否则被拒绝或者任意一个事件校验失败即为忽视
+	lineAcked := make([]bool, len(lineSplits))
+	lineDropped := make([]bool, len(lineSplits))
+	// 按照上报目标分组
+	groups := make(map[*destination]*uploadGroup)
+	destinations := make([]*destination, 0, 1)
+	// 之前已经上报过部分数据的目标
+	var skipped []*destination
 	for _, eventConfig := range eventConfigs {
-		rows := make([]map[string]interface{}, 0, linesSize)
-		for _, cols := range lineSplits {
-			var values = parse(eventConfig, cols)
-			processDefaultProperties(eventConfig, values)
+		dest := target.router.routeFor(source.Operator, source.Server, eventConfig.Name)
+		if dest == nil {
+			// 加载路由时已经校验，不能跳过数据保存断点
+			return fmt.Errorf("%s没有匹配的上报路由 %d %d %s", target, source.Operator, source.Server, eventConfig.Identity())
+		}
+		delivered, partial := deliveredOffset(source, dest)
+		if partial {
+			skipped = append(skipped, dest)
+		}
+		group, ok := groups[dest]
+		if !ok {
+			group = &uploadGroup{sent: make(map[string]int)}
+		}
+		rowCount := len(group.rows)
+		filtered := 0
+		for i, cols := range lineSplits {
+			if partial && cols.offset <= delivered {
+				// 之前的批次已经上报到这个目标
+				lineAcked[i] = true
+				continue
+			}
+			if !acceptRow(eventConfig, cols) {
+				filtered++
+				continue
+			}
+			var row = parse(eventConfig, source, cols)
+			if row == nil {
+				lineDropped[i] = true
+				continue
+			}
+			applyProjectRules(eventConfig, cols, row)
+			applySourceMetadata(eventConfig, source, cols, row)
+			if !processDefaultProperties(eventConfig, row) {
+				lineDropped[i] = true
+				continue
+			}
 
-			dateTime := values["#time"]
-			if dateTime == nil {
-				jsonStr, _ := json.Marshal(values)
-				log.Println("解析[{}]出现异常时间空置数据[{}]", eventConfig.Name, jsonStr)
+			if dateTime, _ := row.fields.get("#time"); dateTime == nil {
+				log.Println("解析[{}]出现异常时间空置数据[{}]", eventConfig.Name, row)
+				lineDropped[i] = true
 				continue
 			}
 
-			rows = append(rows, values)
+			group.rows = append(group.rows, row)
+			group.lines = append(group.lines, i)
+		}
+		rowCount = len(group.rows) - rowCount
+		log.Println("解析类型", eventConfig.RecordName, eventConfig.UploadType, "数据行数", rowCount, "过滤行数", filtered, "上报目标", dest)
+		if rowCount == 0 {
+			continue
+		}
+		group.sent[eventConfig.Name] += rowCount
+		if !ok {
+			groups[dest] = group
+			destinations = append(destinations, dest)
+		}
+	}
+	for i, dest := range destinations {
+		group := groups[dest]
+		err := uploadRows(ctx, dest, group.rows)
+		rejected := false
+		if isPermanent(err) {
+			// 按照策略处理后当前批次可以继续
+			err = handlePermanentFailure(dest, group.rows, err)
+			rejected = err == nil
 		}
-		log.Println("解析类型", eventConfig.RecordName, eventConfig.UploadType, "数据行数", len(rows))
-		jsonValue, err := json.Marshal(rows)
 		if err != nil {
-			panic(err.Error())
+			return err
 		}
-		for retryTimes := 1; retryTimes <= 5; retryTimes++ {
-			err = retryHttpPost(jsonValue)
-			if err == nil {
-				continue LOOP
+		// 只有目标接收的行才计入上报
+		for _, line := range group.lines {
+			if rejected {
+				lineDropped[line] = true
+			} else {
+				lineAcked[line] = true
 			}
-			log.Println(retryTimes, "上传失败，等待2s进行重试", err)
-			time.Sleep(2 * time.Second)
 		}
-		log.Panic("重试次数达到5次，进程直接退出", err)
+		if !rejected {
+			for event, rows := range group.sent {
+				source.Stats.sent(event, rows)
+			}
+		}
+		// 后面的目标失败时，重新处理这一批不再上报到已经成功的目标
+		if i < len(destinations)-1 {
+			markDelivered(source, dest)
+		}
 	}
+	for i := range lineSplits {
+		switch {
+		case lineAcked[i]:
+			source.Stats.acked(1)
+		case lineDropped[i]:
+			source.Stats.dropped(1)
+		default:
+			source.Stats.filtered(1)
+		}
+	}
+	clearDelivered(source, append(skipped, destinations...))
+	return nil
 }
 
-func retryHttpPost(value []byte) (err error) {
-	defer func() {
-		if e := recover(); e != nil {
-			switch e.(type) {
-			case error:
-				err = e.(error)
-			case string:
-				err = errors.New(e.(string))
-			default:
-				log.Println("上传失败未知错误", e)
-				err = errors.New("上传失败")
-			}
-		}
-	}()
-	httpPost(value)
-	return
+// 一批日志中上报到同一个目标的数据
+type uploadGroup struct {
+	rows  []*uploadRow
+	lines []int          // 每一行数据对应的日志行
+	sent  map[string]int // 每个事件的行数
+}
+
+// 一个文件上报到一个目标的进度
+type deliveryKey struct {
+	path string
+	dest *destination
+}
+
+// 同一批次上报到多个目标时部分成功的进度，值为已经上报的最后一行的位置。
+// 只保存在内存中，进程重启后整批重新上报
+var deliveries sync.Map
+
+// 之前部分成功的批次在这个目标已经上报到的位置，没有行位置时无法判断
+func deliveredOffset(source *LogSource, dest *destination) (int64, bool) {
+	if len(source.Offsets) == 0 {
+		return 0, false
+	}
+	if offset, ok := deliveries.Load(deliveryKey{path: source.Path, dest: dest}); ok {
+		return offset.(int64), true
+	}
+	return 0, false
 }
 
-func httpPost(jsonValue []byte) {
-	resultBuffer := bytes.NewBuffer(nil)
-	writer := gzip.NewWriter(resultBuffer)
-	_, err := writer.Write(jsonValue)
+func markDelivered(source *LogSource, dest *destination) {
+	if len(source.Offsets) > 0 {
+		deliveries.Store(deliveryKey{path: source.Path, dest: dest}, source.Offsets[len(source.Offsets)-1])
+	}
+}
+
+// 整批上报完成，断点会保存，不再需要部分成功的进度
+func clearDelivered(source *LogSource, destinations []*destination) {
+	for _, dest := range destinations {
+		deliveries.Delete(deliveryKey{path: source.Path, dest: dest})
+	}
+}
+
+// 上报到指定目标，可以重试的失败按照目标的重试配置指数退避重试，重试全部失败或者ctx取消时返回错误。
+// 永久失败不再重试，直接返回错误，由调用方按照UploadFailurePolicy处理
+func uploadRows(ctx context.Context, target *destination, rows []*uploadRow) error {
+	body, err := encodeRows(rows, target.compressor)
 	if err != nil {
-		if writer != nil {
-			_ = writer.Close()
+		// 重新编码结果相同，不需要重试
+		return permanentError("encode", "%s编码上报数据失败 %d %v", target, len(rows), err)
+	}
+	defer body.release()
+	for retryTimes := 1; retryTimes <= target.retryTimes; retryTimes++ {
+		if ctx.Err() != nil {
+			return fmt.Errorf("%s放弃上报:%v", target, ctx.Err())
+		}
+		err = httpPost(ctx, target, body)
+		target.record(len(rows), err)
+		if err == nil {
+			return nil
+		}
+		if retryTimes > 1 {
+			addMetric(uploadMetrics, target.name+"_retries", 1)
+		}
+		if isPermanent(err) {
+			return err
+		}
+		if retryTimes == target.retryTimes {
+			break
+		}
+		delay := retryDelay(target.retryInterval, retryTimes, err)
+		log.Println(target, retryTimes, "上传失败，等待", delay, "进行重试,连续失败次数", target.consecutiveFailures(), err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
 		}
-		log.Panic("压缩参数内容失败", len(jsonValue), err)
 	}
-	_ = writer.Close()
-	afterGzip := resultBuffer.Len()
-	request, err := http.NewRequest("POST", uploadUrl, resultBuffer)
+	return fmt.Errorf("%s重试次数达到%d次:%v", target, target.retryTimes, err)
+}
+
+// 上报压缩后的内容，重试时复用同一份压缩结果。返回的错误区分是否可以重试
+func httpPost(ctx context.Context, target *destination, body *uploadBody) error {
+	compressed := len(body.Bytes())
+	request, err := http.NewRequestWithContext(ctx, "POST", target.url, bytes.NewReader(body.Bytes()))
 	if err != nil {
-		log.Panic("构建参数异常", len(jsonValue), afterGzip, err)
+		return permanentError("request", "构建参数异常 %d %d %v", body.size, compressed, err)
+	}
+	for k, v := range httpHeaders {
+		request.Header.Set(k, v)
 	}
 	request.Header.Set("Content-Type", "application/json")
-	request.Header.Set("appid", uploadAppId)
-	request.Header.Set("compress", "gzip")
+	request.Header.Set("appid", target.appId)
+	request.Header.Set("compress", body.compression)
+	if len(target.token) > 0 {
+		request.Header.Set("Authorization", "Bearer "+target.token)
+	}
 	startTime := time.Now()
 	res, err := httpClient.Do(request)
 	duration := time.Now().Sub(startTime)
@@ -144,179 +300,230 @@ func httpPost(jsonValue []byte) {
 		defer func() { _ = res.Body.Close() }()
 	}
 	if err != nil {
-		log.Panic("上报数据失败", len(jsonValue), err.Error())
+		return retryableError("network", "上报数据失败 %d %s", body.size, err.Error())
 	}
 	if res.StatusCode != 200 {
-		log.Panic("上报数据失败", len(jsonValue), res.Status)
+		return statusError(res)
 	}
-	body, err := ioutil.ReadAll(res.Body)
+	resBody, err := ioutil.ReadAll(res.Body)
 	if err != nil {
-		log.Panic("读取上报返回异常", len(jsonValue), err.Error())
+		return retryableError("network", "读取上报返回异常 %d %s", body.size, err.Error())
 	}
 	shuShuRes := &model.ShuShuHttpRes{}
-	err = json.Unmarshal(body, shuShuRes)
+	err = json.Unmarshal(resBody, shuShuRes)
 	if err != nil {
-		log.Panic("解析上报返回失败", err.Error())
-	}
-	if shuShuRes.Code == 0 {
-		log.Println("上报数数数据成功", afterGzip, duration)
-		return
-	}
-	switch shuShuRes.Code {
-	case -1:
-		log.Panic("数数上报异常", shuShuRes.Msg, "invalid data format")
-	case -2:
-		log.Panic("数数上报异常", shuShuRes.Msg, "APP ID doesn't exist")
-	case -3:
-		log.Panic("数数上报异常", shuShuRes.Msg, "invalid ip transmission")
-	default:
-		log.Panic("Unexpected response return code", shuShuRes.Code)
+		// 代理或者网关返回的错误页面
+		return retryableError("response", "解析上报返回失败 %s", err.Error())
 	}
+	if shuShuRes.Code != 0 {
+		return responseError(shuShuRes)
+	}
+	log.Println(target, "上报数数数据成功", compressed, duration)
+	return nil
 }
 
-func processDefaultProperties(eventConfig *model.EventConfig, values map[string]interface{}) {
+// 根据上报类型补充默认字段并校验，返回false表示当前行不能上报
+func processDefaultProperties(eventConfig *model.EventConfig, row *uploadRow) bool {
 	uploadType := eventConfig.UploadType
-	values["#type"] = uploadType
-	if "track" == uploadType {
-		eventName := eventConfig.Name
-		values["#event_name"] = eventName
-	} else if ("user_set" != uploadType) && ("user_setOnce" != uploadType) {
-		return
+	row.fields.set("#type", uploadType)
+	switch uploadType {
+	case model.UploadTrack:
+		row.fields.set("#event_name", eventConfig.Name)
+		// 首次事件的校验id为空时按普通事件上报
+		if firstCheckId, ok := row.fields.get("#first_check_id"); ok && isEmptyValue(firstCheckId) {
+			row.fields.remove("#first_check_id")
+		}
+	case model.UploadTrackUpdate, model.UploadTrackOverwrite:
+		row.fields.set("#event_name", eventConfig.Name)
+		if eventId, _ := row.fields.get("#event_id"); isEmptyValue(eventId) {
+			log.Println(eventConfig.Name, uploadType, "缺少#event_id,忽视当前行")
+			return false
+		}
+	case model.UploadUserSet, model.UploadUserSetOnce:
+	case model.UploadUserAdd:
+		// user_add只能累加数值属性
+		row.properties.filter(func(k string, v interface{}) bool {
+			switch v.(type) {
+			case int, int64, float64:
+				return true
+			}
+			log.Println(eventConfig.Name, uploadType, "属性", k, "不是数值类型,忽视此属性", v)
+			return false
+		})
+		return len(row.properties) > 0
+	case model.UploadUserAppend:
+		// user_append只能追加列表属性
+		row.properties.filter(func(k string, v interface{}) bool {
+			if v == nil || reflect.TypeOf(v).Kind() != reflect.Slice {
+				log.Println(eventConfig.Name, uploadType, "属性", k, "不是列表类型,忽视此属性", v)
+				return false
+			}
+			return true
+		})
+		return len(row.properties) > 0
+	case model.UploadUserUnset:
+		// user_unset只需要属性名，值统一为0
+		for i := range row.properties {
+			row.properties[i].value = 0
+		}
+		return len(row.properties) > 0
+	case model.UploadUserDel:
+		row.properties = nil
+		return true
+	default:
+		return false
 	}
-
-	account := values["#account_id"].(string)
-	if account == "" || "-1" == account {
-		return
+	if !model.IsTrackType(uploadType) {
+		row.fields.remove("#event_name")
+		row.fields.remove("#event_id")
+		row.fields.remove("#first_check_id")
 	}
+	return true
+}
 
-	index := strings.LastIndex(account, ".")
-	if index >= 0 {
-		account = string([]byte(account)[0:index])
-	}
-	indexOf := strings.LastIndex(account, "_")
-	var userId string
-	if indexOf <= 0 {
-		userId = account
-	} else {
-		userId = string([]byte(account)[0:indexOf])
+func isEmptyValue(value interface{}) bool {
+	if value == nil {
+		return true
 	}
-	//noinspection unchecked
-	properties := values["properties"]
-	if properties == nil {
-		properties = map[string]interface{}{"userId": userId}
-		values["properties"] = properties
-	} else {
-		properties.(map[string]interface{})["userId"] = userId
+	if str, ok := value.(string); ok {
+		return len(str) == 0
 	}
+	return false
 }
 
-func parse(eventConfig *model.EventConfig, cols []string) map[string]interface{} {
+// 解析一行日志，日期按照服务器时区格式化
+func parse(eventConfig *model.EventConfig, source *LogSource, cols *logRow) *uploadRow {
+	var location *time.Location
+	if source != nil {
+		location = source.Location
+	}
+	location = locationOrLocal(location)
+	now := time.Now()
 	fields := eventConfig.Fields
-	values := make(map[string]interface{}, len(fields))
+	row := newUploadRow(8, len(fields))
 
-	values["#type"] = eventConfig.UploadType
+	row.fields.set("#type", eventConfig.UploadType)
 
-	properties := make(map[string]interface{})
 	for name, field := range fields {
-		index := field.Index
-		if int(index) > len(cols) {
-			log.Println("日志[{}]列[{}]下标[{}]大约最大值[{}]", eventConfig.Name, name, index, len(cols))
+		var strValue string
+		var ok bool
+		if len(field.Expression) > 0 {
+			strValue, ok = evalField(eventConfig, name, field, cols)
+		} else {
+			strValue, ok = cols.Get(field)
+		}
+		if !ok {
+			log.Println("日志", eventConfig.Name, "列", name, "下标", field.Index, field.Column, "不存在")
 			continue
 		}
-		fieldType := field.DataType
-		strValue := cols[index-1]
-		if "server" == name {
-			strValue = "9999"
+		if overrideValue, ok := overrideField(eventConfig, name, cols); ok {
+			strValue = overrideValue
 		}
 		var value interface{}
 		var err error
-		switch fieldType {
-		case "string":
-			if len(strValue) > 1024 {
-				strValue = string(([]byte(strValue))[0:1024])
-			}
-			value = strValue
-		case "int":
-			value, err = strconv.ParseInt(strValue, 10, 64)
-			if err != nil {
-				if ignoreFieldError {
-					value = 0
-				} else {
-					log.Panic("解析", name, "失败", strValue, err.Error())
-				}
-			}
-		case "float":
-			value, err = strconv.ParseFloat(strValue, 64)
-			if err != nil {
-				if ignoreFieldError {
-					value = 0.0
-				} else {
-					log.Panic("解析", name, "失败", strValue, err.Error())
-				}
-			}
-		case "date":
-			millSec, err := strconv.ParseInt(strValue, 10, 64)
-			if err != nil {
-				if ignoreFieldError {
-					millSec = 0
-				} else {
-					log.Panic("解析", name, "失败", strValue, err.Error())
-				}
+		if isDateKind(field.Kind) {
+			curTime, err := parseDate(field, strValue, location)
+			if err != nil && !ignoreFieldError {
+				log.Panic("解析", name, "失败", strValue, err.Error())
 			}
 			if "#time" == name && err != nil {
-				joinStr := strings.Join(cols, "@")
+				joinStr := cols.String()
 				log.Println("解析日期字段错误，忽视数据行>>", joinStr)
 				return nil
 			}
-			curTime := time.Unix(0, int64(time.Duration(millSec)*time.Millisecond))
-			value = curTime.Format("2006-01-02 15:04:05.000")
-			if curTime.After(time.Now()) {
-				joinStr := strings.Join(cols, "@")
-				log.Println(eventConfig.Name, "解析出日期大于当前日期,忽视当前行", curTime, strValue, ">>", joinStr)
+			curTime = curTime.In(location)
+			if "#time" == name {
+				var keep bool
+				if curTime, keep = checkEventTime(eventConfig, source, cols, curTime, now); !keep {
+					return nil
+				}
+				row.fields.set("#zone_offset", zoneOffset(curTime))
+			} else if futurePolicy.enabled && curTime.After(now.Add(futurePolicy.tolerance)) {
+				joinStr := cols.String()
+				log.Println(eventConfig.Name, "解析出日期大于当前日期,忽视当前字段", name, curTime, strValue, ">>", joinStr)
 				continue
 			}
-		case "bool":
-			value, _ = strconv.ParseBool(strValue)
-		case "[I":
-			array := make([]int, 0, 3)
-			err = json.Unmarshal([]byte(strValue), &array)
+			value = curTime.Format("2006-01-02 15:04:05.000")
+		} else {
+			value, err = convertValue(field, strValue)
 			if err != nil && !ignoreFieldError {
 				log.Panic("解析", name, "失败", strValue, err.Error())
 			}
-			value = array
 		}
 
 		if strings.HasPrefix(name, "#") {
-			values[name] = value
+			row.fields.set(name, value)
 		} else if strings.HasPrefix(name, "${") {
-			properties[getRealName(name, cols)] = value
+			row.properties.set(getRealName(name, cols), value)
 		} else {
-			properties[name] = value
+			row.properties.set(name, value)
 		}
 	}
 
-	if len(properties) > 0 {
-		values["properties"] = properties
-	}
+	return row
+}
 
-	return values
+// 通过表达式计算字段值
+func evalField(eventConfig *model.EventConfig, name string, field *model.Field, cols *logRow) (string, bool) {
+	strValue, err := mustExpression(field.Expression).EvalString(&exprContext{row: cols})
+	if err != nil {
+		log.Println("日志", eventConfig.Name, "列", name, "计算表达式失败", field.Expression, err)
+		return "", false
+	}
+	return strValue, true
 }
 
-func getRealName(name string, cols []string) string {
-	nameByte := []byte(name)
-	index := nameByte[strings.Index(name, "{")+1 : strings.Index(name, "}")]
-	indexValue, err := strconv.Atoi(string(index))
+// 动态属性名${12}按照下标获取，${name}按照列名获取
+func getRealName(name string, cols *logRow) string {
+	key := name[strings.Index(name, "{")+1 : strings.Index(name, "}")]
+	indexValue, err := strconv.Atoi(key)
+	var realName string
+	var ok bool
 	if err != nil {
-		panic(err.Error())
+		realName, ok = cols.ByName(key)
+	} else {
+		realName, ok = cols.ByIndex(indexValue)
+	}
+	if !ok {
+		panic("动态属性名" + name + "对应的列不存在")
 	}
-	return cols[indexValue-1]
+	return realName
 }
 
-func splitLines(lines []string) [][]string {
-	fieldLines := make([][]string, 0, len(lines))
-	for _, line := range lines {
-		fields := strings.Split(line, "\t")
-		fieldLines = append(fieldLines, fields)
+// 按照解码器解析每一行，解析失败的行忽视
+func decodeLines(decoderName string, lines []string, offsets []int64) []*logRow {
+	decoder, err := NewLineDecoder(decoderName)
+	if err != nil {
+		panic(err.Error())
+	}
+	fieldLines := make([]*logRow, 0, len(lines))
+	if batch, ok := decoder.(batchDecoder); ok {
+		// 所有行和列位置一次分配
+		rows := make([]logRow, len(lines))
+		ends := make([]int32, 0, len(lines)*32)
+		for i, line := range lines {
+			ends, err = batch.decodeInto(&rows[i], line, ends)
+			if err != nil {
+				log.Println("解码日志行失败,忽视此行", decoderName, err, ">>", line)
+				continue
+			}
+			if i < len(offsets) {
+				rows[i].offset = offsets[i]
+			}
+			fieldLines = append(fieldLines, &rows[i])
+		}
+		return fieldLines
+	}
+	for i, line := range lines {
+		row, err := decoder.Decode(line)
+		if err != nil {
+			log.Println("解码日志行失败,忽视此行", decoderName, err, ">>", line)
+			continue
+		}
+		if i < len(offsets) {
+			row.offset = offsets[i]
+		}
+		fieldLines = append(fieldLines, row)
 	}
 	return fieldLines
 }
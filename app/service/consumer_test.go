@@ -2,8 +2,10 @@ package service
 
 import (
 	"encoding/json"
+	"strings"
 	"testing"
 	"time"
+	"xai.com/shushu/app/model"
 )
 
 func TestDateFormat(t *testing.T) {
@@ -27,3 +29,79 @@ func TestJsonBoolean(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestProcessTrackUpdate(t *testing.T) {
+	eventConfig := &model.EventConfig{Name: "item_record", UploadType: model.UploadTrackUpdate}
+	values := testUploadRow(map[string]interface{}{"#account_id": "acc.1_1", "#time": "2021-05-02 00:00:00.000"}, nil)
+	if processDefaultProperties(eventConfig, values) {
+		t.Fatal("track_update缺少#event_id应该被忽视")
+	}
+	values.fields.set("#event_id", "order_1")
+	if !processDefaultProperties(eventConfig, values) {
+		t.Fatal("track_update配置#event_id应该上报")
+	}
+	if eventName, _ := values.fields.get("#event_name"); eventName != "item_record" {
+		t.Fatal("缺少#event_name", values)
+	}
+}
+
+func TestProcessFirstCheckId(t *testing.T) {
+	eventConfig := &model.EventConfig{Name: "register", UploadType: model.UploadTrack}
+	values := testUploadRow(map[string]interface{}{"#account_id": "acc", "#first_check_id": ""}, nil)
+	processDefaultProperties(eventConfig, values)
+	if _, ok := values.fields.get("#first_check_id"); ok {
+		t.Fatal("空#first_check_id应该被删除")
+	}
+}
+
+func TestProcessUserAdd(t *testing.T) {
+	eventConfig := &model.EventConfig{UploadType: model.UploadUserAdd}
+	values := testUploadRow(map[string]interface{}{"#account_id": "acc"}, map[string]interface{}{"gold": int64(10), "name": "abc"})
+	if !processDefaultProperties(eventConfig, values) {
+		t.Fatal("user_add应该上报")
+	}
+	properties := values.properties
+	if gold, _ := properties.get("gold"); len(properties) != 1 || gold != int64(10) {
+		t.Fatal("user_add只能保留数值属性", properties)
+	}
+	if _, ok := properties.get("userId"); ok {
+		t.Fatal("user_add不能设置userId")
+	}
+}
+
+func TestProcessUserUnsetAndDel(t *testing.T) {
+	values := testUploadRow(map[string]interface{}{"#account_id": "acc"}, map[string]interface{}{"gold": int64(10)})
+	processDefaultProperties(&model.EventConfig{UploadType: model.UploadUserUnset}, values)
+	if gold, _ := values.properties.get("gold"); gold != 0 {
+		t.Fatal("user_unset属性值应该为0", values)
+	}
+	processDefaultProperties(&model.EventConfig{UploadType: model.UploadUserDel}, values)
+	if strings.Contains(values.String(), "properties") {
+		t.Fatal("user_del不能包含properties")
+	}
+}
+
+func TestValidateEventConfig(t *testing.T) {
+	fields := map[string]*model.Field{
+		"#account_id": {Index: 5, DataType: "string"},
+		"#time":       {Index: 10, DataType: "date"},
+		"gold":        {Index: 12, DataType: "string"},
+	}
+	for _, field := range fields {
+		_ = compileFieldType(field, nil)
+	}
+	config := &model.EventConfig{UploadType: model.UploadUserAdd, Fields: fields}
+	if validateEventConfig(config) == nil {
+		t.Fatal("user_add字符串属性应该校验失败")
+	}
+	fields["gold"].DataType = "int"
+	_ = compileFieldType(fields["gold"], nil)
+	if err := validateEventConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	config.UploadType = model.UploadTrackOverwrite
+	config.Name = "gold_change"
+	if validateEventConfig(config) == nil {
+		t.Fatal("track_overwrite缺少#event_id应该校验失败")
+	}
+}
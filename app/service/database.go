@@ -1,13 +1,16 @@
 package service
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	_ "github.com/go-sql-driver/mysql"
-	"io/ioutil"
+	_ "github.com/lib/pq"
 	"log"
+	"strings"
 	"sync"
 	"time"
+	"xai.com/shushu/app/model"
 )
 
 type logPosition struct {
@@ -32,6 +35,9 @@ type logPosition struct {
 
 	// 累计行数
 	TotalRows int
+
+	// 输出目标，不入库，记录在id后缀中
+	Sink string
 }
 
 func (p *logPosition) String() string {
@@ -39,106 +45,121 @@ func (p *logPosition) String() string {
 }
 
 var dbPool *sql.DB
+var dbDialect *dialect
 var once sync.Once
 
-func InitDatabase(userName, password, addr, database string) {
+// 复用的预编译语句
+var (
+	loadStmt   *sql.Stmt
+	upsertStmt *sql.Stmt
+	ledgerStmt *sql.Stmt
+)
+
+const positionColumns = "id,operator,server,log,type,last_execute,position,total_rows"
+
+const loadSql = "select " + positionColumns + " from log_position where id=?"
+
+// 初始化断点数据库，支持mysql,sqlite,postgres
+func InitDatabase(config *model.AppConfig) {
 	once.Do(func() {
-		initDatabase(userName, password, addr, database)
+		initDatabase(config)
 	})
 }
 
-func initDatabase(userName string, password string, addr string, database string) {
-	dsn := fmt.Sprintf("%s:%s@%s(%s)/%s?charset=utf8&parseTime=True", userName, password, "tcp", addr, database)
-	db, err := sql.Open("mysql", dsn)
+func initDatabase(config *model.AppConfig) {
+	databaseType := strings.TrimSpace(config.DatabaseType)
+	if len(databaseType) == 0 {
+		databaseType = "mysql"
+	}
+	d, ok := dialects[databaseType]
+	if !ok {
+		panic("不支持的数据库类型" + databaseType)
+	}
+	if d.name == "sqlite" && !sqliteSupported {
+		panic("当前程序编译时没有开启cgo,不支持sqlite断点数据库,请使用CGO_ENABLED=1编译或者使用mysql,postgres")
+	}
+	dsn := databaseDsn(config, d)
+	db, err := sql.Open(d.driver, dsn)
 	if err != nil {
-		panic("mysql初始化失败" + dsn + ";" + err.Error())
+		panic(d.name + "初始化失败" + dsn + ";" + err.Error())
 	}
-	dbPool = db
-	dbPool.SetConnMaxLifetime(60 * time.Second) //最大连接周期，超过时间的连接就close
-	dbPool.SetMaxOpenConns(5)                   //设置最大连接数
-	dbPool.SetMaxIdleConns(2)                   //设置闲置连接数
+	db.SetConnMaxLifetime(60 * time.Second) //最大连接周期，超过时间的连接就close
+	db.SetMaxOpenConns(5)                   //设置最大连接数
+	db.SetMaxIdleConns(2)                   //设置闲置连接数
+	if d.name == "sqlite" {
+		// sqlite同时只能有一个写入
+		db.SetMaxOpenConns(1)
+	}
+	useDatabase(db, d)
+}
 
-	content, err := ioutil.ReadFile("config/init.sql")
-	result, err := dbPool.Exec(string(content))
+// 升级数据库结构并预编译语句
+func useDatabase(db *sql.DB, d *dialect) {
+	version, err := migrate(context.Background(), db, d)
 	if err != nil {
 		panic("初始化LogPosition表失败" + err.Error())
 	}
-	affect, err := result.RowsAffected()
+	log.Println("初始化数据库成功", d.name, "版本", version)
+	dbPool = db
+	dbDialect = d
+	prepareStatements()
+}
+
+func prepareStatements() {
+	var err error
+	loadStmt, err = dbPool.Prepare(dbDialect.rebind(loadSql))
 	if err != nil {
-		panic("插入数据库失败" + err.Error())
+		panic("数据库查询语句预编译失败" + err.Error())
 	}
-	if affect > 0 {
-		log.Println("初始化数据库成功,新建数据表")
-	} else {
-		log.Println("初始化数据库成功")
+	// 断点不存在时插入，存在时只更新日期，位置和行数
+	upsertStmt, err = dbPool.Prepare(dbDialect.rebind(dbDialect.upsert("log_position", positionColumns, "last_execute,position,total_rows")))
+	if err != nil {
+		panic("数据库更新语句预编译失败" + err.Error())
 	}
+	ledgerStmt, err = dbPool.Prepare(dbDialect.rebind(dbDialect.upsert("file_ledger", ledgerColumns, ledgerUpdateColumns)))
+	if err != nil {
+		panic("上报记录更新语句预编译失败" + err.Error())
+	}
+}
+
+// 断点id，默认输出目标为 运营商_服务器_日志名，其他输出目标增加 @输出目标 后缀
+func positionId(operator, server int, recordName, sink string) string {
+	id := fmt.Sprintf("%d_%d_%s", operator, server, recordName)
+	if sink != defaultSinkName {
+		id += "@" + sink
+	}
+	return id
 }
 
-func LoadOne(operator, server int, recordName string) *logPosition {
+func LoadOne(ctx context.Context, operator, server int, recordName, sink string) (*logPosition, error) {
 	if dbPool == nil {
 		panic("数据库未完成初始化")
 	}
-	id := fmt.Sprintf("%d_%d_%s", operator, server, recordName)
-	stmt, err := dbPool.Prepare("select `id`,`operator`,`server`,`log`,`type`,`last_execute`,`position`,`total_rows` from log_position where id=?")
-	defer func() {
-		err := stmt.Close()
-		if err != nil {
-			log.Println(err)
-		}
-	}()
-	if err != nil {
-		panic("数据库查询失败" + err.Error())
-	}
-	row := stmt.QueryRow(id)
-	entity := &logPosition{}
-	err = row.Scan(&entity.Id, &entity.Operator, &entity.Server, &entity.Log, &entity.LogType, &entity.LastExecute, &entity.Position, &entity.TotalRows)
+	id := positionId(operator, server, recordName, sink)
+	row := loadStmt.QueryRowContext(ctx, id)
+	entity := &logPosition{Sink: sink}
+	err := row.Scan(&entity.Id, &entity.Operator, &entity.Server, &entity.Log, &entity.LogType, &entity.LastExecute, &entity.Position, &entity.TotalRows)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil
+			return nil, nil
 		}
-		panic("数据库查询失败" + err.Error())
+		return nil, fmt.Errorf("数据库查询失败%s", err.Error())
 	}
-	return entity
+	return entity, nil
 }
 
-func SaveToDb(position *logPosition) {
+// 保存断点，ctx取消时放弃保存并返回错误
+func SaveToDb(ctx context.Context, position *logPosition) error {
 	if dbPool == nil {
 		panic("数据库未完成初始化")
 	}
-	preEntity := LoadOne(position.Operator, position.Server, position.Log)
-	var result sql.Result
-	var err error
-	if preEntity != nil {
-		stmt, preErr := dbPool.Prepare("update log_position set `last_execute`=?,`position`=?,`total_rows`=? where `id`=?")
-		if preErr != nil {
-			panic("数据库更新失败" + preErr.Error())
-		}
-		defer func() {
-			err := stmt.Close()
-			if err != nil {
-				log.Println(err)
-			}
-		}()
-		result, err = stmt.Exec(position.LastExecute, position.Position, position.TotalRows, position.Id)
-	} else {
-		stmt, preErr := dbPool.Prepare("insert into log_position(`id`,`operator`,`server`,`log`,`type`,`last_execute`,`position`,`total_rows`) values(?,?,?,?,?,?,?,?)")
-		if preErr != nil {
-			panic("数据库插入失败" + preErr.Error())
-		}
-		defer func() {
-			err := stmt.Close()
-			if err != nil {
-				log.Println(err)
-			}
-		}()
-		result, err = stmt.Exec(position.Id, position.Operator, position.Server, position.Log, position.LogType, position.LastExecute, position.Position, position.TotalRows)
-	}
+	return upsertPosition(ctx, upsertStmt, position)
+}
 
+func upsertPosition(ctx context.Context, stmt *sql.Stmt, position *logPosition) error {
+	_, err := stmt.ExecContext(ctx, position.Id, position.Operator, position.Server, position.Log, position.LogType, position.LastExecute, position.Position, position.TotalRows)
 	if err != nil {
-		panic(position.Id + "保存记录操作失败" + err.Error())
-	}
-	_, err = result.RowsAffected()
-	if err != nil {
-		panic(position.Id + "保存记录Row失败" + err.Error())
+		return fmt.Errorf("%s保存记录操作失败%s", position.Id, err.Error())
 	}
+	return nil
 }
@@ -0,0 +1,143 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"xai.com/shushu/app/model"
+)
+
+// 使用临时sqlite文件作为断点数据库
+func useSqliteDatabase(t *testing.T) func() {
+	if !sqliteSupported {
+		t.Skip("没有开启cgo,不支持sqlite")
+	}
+	dir, err := ioutil.TempDir("", "checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "log_position.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	useDatabase(db, dialects["sqlite"])
+	return func() {
+		_ = db.Close()
+		dbPool = nil
+		_ = os.RemoveAll(dir)
+	}
+}
+
+func TestSqliteCheckpoint(t *testing.T) {
+	defer useSqliteDatabase(t)()
+	ctx := context.Background()
+	day, _ := time.Parse("2006-01-02", "2021-04-20")
+	position := &logPosition{Id: positionId(1, 2, "charge_record", "backup"), Operator: 1, Server: 2, Log: "charge_record", LogType: "tlog", LastExecute: day, Sink: "backup"}
+	if err := SaveToDb(ctx, position); err != nil {
+		t.Fatal(err)
+	}
+	position.Position, position.TotalRows = 1024, 10
+	if err := SaveToDb(ctx, position); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadOne(ctx, 1, 2, "charge_record", "backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded == nil || loaded.Position != 1024 || loaded.TotalRows != 10 || !loaded.LastExecute.Equal(day) || loaded.Sink != "backup" {
+		t.Fatal("断点读取错误", loaded)
+	}
+	if missing, err := LoadOne(ctx, 1, 3, "charge_record", "backup"); err != nil || missing != nil {
+		t.Fatal("不存在的断点应该返回nil", missing, err)
+	}
+
+	// 批量写入
+	position.Position = 2048
+	err = writeCheckpoints(ctx, map[string]logPosition{position.Id: *position, "1_3_charge_record": {Id: "1_3_charge_record", Operator: 1, Server: 3, LastExecute: day}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded, _ := LoadOne(ctx, 1, 2, "charge_record", "backup"); loaded.Position != 2048 {
+		t.Fatal("批量写入错误", loaded)
+	}
+}
+
+func TestMigrateTwice(t *testing.T) {
+	defer useSqliteDatabase(t)()
+	version, err := migrate(context.Background(), dbPool, dialects["sqlite"])
+	if err != nil || version != migrations[len(migrations)-1].version {
+		t.Fatal("重复升级应该保持当前版本", version, err)
+	}
+	var count int
+	if err := dbPool.QueryRow("select count(*) from schema_version").Scan(&count); err != nil || count != len(migrations) {
+		t.Fatal("版本记录错误", count, err)
+	}
+}
+
+// mysql的DDL会隐式提交，升级中途失败时部分语句已经生效，重新升级时需要跳过
+func TestMigrateHalfApplied(t *testing.T) {
+	defer useSqliteDatabase(t)()
+	ctx := context.Background()
+	for _, statement := range []string{
+		"delete from schema_version where version>=2",
+		"drop index idx_file_ledger_day",
+		"alter table file_ledger drop column reconcile",
+	} {
+		if _, err := dbPool.ExecContext(ctx, statement); err != nil {
+			t.Fatal(statement, err)
+		}
+	}
+	version, err := migrate(ctx, dbPool, dialects["sqlite"])
+	if err != nil || version != migrations[len(migrations)-1].version {
+		t.Fatal("部分执行的升级应该可以重新执行", version, err)
+	}
+	tx, err := dbPool.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = tx.Rollback() }()
+	for _, change := range append([]schemaChange{ledgerDayIndex}, ledgerReconcileSql...) {
+		if applied, err := dialects["sqlite"].applied(ctx, tx, change); err != nil || !applied {
+			t.Fatal("重新升级后应该存在", change.sql, err)
+		}
+	}
+}
+
+func TestMigrationStatements(t *testing.T) {
+	for i, m := range migrations {
+		if m.version != i+1 {
+			t.Fatal("数据库版本必须连续", m.version)
+		}
+		for name := range dialects {
+			if len(m.statements[name]) == 0 {
+				t.Fatal("数据库版本", m.version, "缺少", name, "语句")
+			}
+		}
+	}
+}
+
+func TestRebind(t *testing.T) {
+	if query := dialects["postgres"].rebind("select ? from t where a=? and b=?"); query != "select $1 from t where a=$2 and b=$3" {
+		t.Fatal("postgres占位符转换错误", query)
+	}
+	if query := dialects["mysql"].rebind("select ?"); query != "select ?" {
+		t.Fatal("mysql不需要转换", query)
+	}
+}
+
+func TestSqliteWithoutCgo(t *testing.T) {
+	if sqliteSupported {
+		t.Skip("开启cgo时支持sqlite")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatal("没有开启cgo时配置sqlite应该启动失败")
+		}
+	}()
+	initDatabase(&model.AppConfig{DatabaseType: "sqlite", DatabaseDsn: filepath.Join(os.TempDir(), "log_position.db")})
+}
@@ -0,0 +1,100 @@
+package service
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// 死信记录，无法上报的数据写入死信文件，便于排查和补发
+type deadLetterRecord struct {
+	Time   string `json:"time"`
+	Reason string `json:"reason"`
+	Event  string `json:"event,omitempty"`
+	Target string `json:"target,omitempty"`
+	File   string `json:"file,omitempty"`
+	Offset int64  `json:"offset"`
+	Line   string `json:"line,omitempty"`
+	Data   string `json:"data,omitempty"`
+}
+
+var deadLetter struct {
+	sync.Mutex
+	path string
+	file *os.File
+}
+
+// 设置死信文件路径，为空时不记录死信
+func initDeadLetter(path string) {
+	deadLetter.Lock()
+	defer deadLetter.Unlock()
+	if deadLetter.file != nil {
+		_ = deadLetter.file.Close()
+		deadLetter.file = nil
+	}
+	deadLetter.path = path
+}
+
+func deadLetterEnabled() bool {
+	deadLetter.Lock()
+	defer deadLetter.Unlock()
+	return len(deadLetter.path) > 0
+}
+
+// 写入一条死信记录，每条记录一行json
+func writeDeadLetter(record *deadLetterRecord) {
+	deadLetter.Lock()
+	defer deadLetter.Unlock()
+	if len(deadLetter.path) == 0 {
+		return
+	}
+	if deadLetter.file == nil {
+		if dir := filepath.Dir(deadLetter.path); len(dir) > 0 {
+			_ = os.MkdirAll(dir, 0755)
+		}
+		file, err := os.OpenFile(deadLetter.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			log.Println("打开死信文件失败", deadLetter.path, err)
+			return
+		}
+		deadLetter.file = file
+	}
+	record.Time = time.Now().Format("2006-01-02 15:04:05.000")
+	content, err := json.Marshal(record)
+	if err != nil {
+		log.Println("序列化死信失败", err)
+		return
+	}
+	if _, err = deadLetter.file.Write(append(content, '\n')); err != nil {
+		log.Println("写入死信文件失败", deadLetter.path, err)
+	}
+}
+
+// 一批日志中等待写入的死信，同一行只记录一次
+type pendingDeadLetters struct {
+	rows    map[*logRow]bool
+	records []*deadLetterRecord
+}
+
+// 记录当前批次的死信，断点保存后再写入，失败重新处理的批次不会重复写入
+func (s *LogSource) deferDeadLetter(row *logRow, record *deadLetterRecord) {
+	if s.deadLetters.rows == nil {
+		s.deadLetters.rows = make(map[*logRow]bool)
+	}
+	if s.deadLetters.rows[row] {
+		return
+	}
+	s.deadLetters.rows[row] = true
+	s.deadLetters.records = append(s.deadLetters.records, record)
+}
+
+// 断点保存后写入当前批次的死信
+func (s *LogSource) flushDeadLetters() {
+	for _, record := range s.deadLetters.records {
+		writeDeadLetter(record)
+	}
+	s.deadLetters = pendingDeadLetters{}
+}
@@ -0,0 +1,286 @@
+package service
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"xai.com/shushu/app/model"
+)
+
+// 默认的日志行解码器，游戏服tlog/flog使用tab分割
+const defaultDecoder = "tsv"
+
+// 日志行解码器，将一行日志解析为可以按照下标或者名称访问的列
+type LineDecoder interface {
+	Decode(line string) (*logRow, error)
+
+	// 是否按照名称访问列(json,kv)，否则按照下标访问
+	Named() bool
+}
+
+// 支持批量解码的解码器，解码到预先分配的logRow中，多行共用列位置切片
+type batchDecoder interface {
+	decodeInto(row *logRow, line string, ends []int32) ([]int32, error)
+}
+
+// 解码后的一行日志
+type logRow struct {
+	line   string
+	offset int64             // 在文件中的起始位置
+	cols   []string          // 按照下标访问的列
+	ends   []int32           // 分隔符格式每一列在line中的结束位置，按照下标访问时截取子串，不拆分字符串
+	sepLen int               // 分隔符长度
+	named  map[string]string // 按照名称访问的列
+}
+
+// 按照字段配置获取列值，json,kv格式按照列名获取，其他格式按照下标(从1开始)获取
+func (r *logRow) Get(field *model.Field) (string, bool) {
+	if r.named != nil {
+		return r.ByName(field.Column)
+	}
+	return r.ByIndex(int(field.Index))
+}
+
+func (r *logRow) ByIndex(index int) (string, bool) {
+	if r.ends != nil {
+		if index <= 0 || index > len(r.ends) {
+			return "", false
+		}
+		start := 0
+		if index > 1 {
+			start = int(r.ends[index-2]) + r.sepLen
+		}
+		return r.line[start:r.ends[index-1]], true
+	}
+	if index <= 0 || index > len(r.cols) {
+		return "", false
+	}
+	return r.cols[index-1], true
+}
+
+func (r *logRow) ByName(name string) (string, bool) {
+	if r.named == nil {
+		return "", false
+	}
+	value, ok := r.named[name]
+	return value, ok
+}
+
+func (r *logRow) String() string {
+	return r.line
+}
+
+var decoderCache sync.Map
+
+// 根据配置获取解码器，支持 tsv, csv, csv(;), delim(|), json, kv, kv(&,=)
+func NewLineDecoder(spec string) (LineDecoder, error) {
+	spec = strings.TrimSpace(spec)
+	if len(spec) == 0 {
+		spec = defaultDecoder
+	}
+	if decoder, ok := decoderCache.Load(spec); ok {
+		return decoder.(LineDecoder), nil
+	}
+	name, args := spec, ""
+	if start := strings.Index(spec, "("); start > 0 && strings.HasSuffix(spec, ")") {
+		name, args = spec[:start], spec[start+1:len(spec)-1]
+	}
+	var decoder LineDecoder
+	switch name {
+	case "tsv":
+		decoder = &delimiterDecoder{sep: "\t"}
+	case "delim":
+		if len(args) == 0 {
+			return nil, errors.New("delim解码器必须配置分隔符,如delim(|)")
+		}
+		decoder = &delimiterDecoder{sep: args}
+	case "csv":
+		comma := ','
+		if len(args) > 0 {
+			runes := []rune(args)
+			if len(runes) != 1 {
+				return nil, fmt.Errorf("csv分隔符只能是单个字符[%s]", args)
+			}
+			comma = runes[0]
+		}
+		decoder = &csvDecoder{comma: comma}
+	case "json":
+		decoder = &jsonDecoder{}
+	case "kv":
+		pairSep, kvSep := " ", "="
+		if len(args) > 0 {
+			parts := strings.SplitN(args, ",", 2)
+			if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+				return nil, fmt.Errorf("kv解码器配置错误[%s],格式为kv(分隔符,连接符)", spec)
+			}
+			pairSep, kvSep = parts[0], parts[1]
+		}
+		decoder = &kvDecoder{pairSep: pairSep, kvSep: kvSep}
+	default:
+		return nil, fmt.Errorf("不支持的日志解码器[%s]", spec)
+	}
+	actual, _ := decoderCache.LoadOrStore(spec, decoder)
+	return actual.(LineDecoder), nil
+}
+
+// 按照固定分隔符拆分
+type delimiterDecoder struct {
+	sep string
+}
+
+func (d *delimiterDecoder) Decode(line string) (*logRow, error) {
+	row := &logRow{}
+	d.decodeInto(row, line, nil)
+	return row, nil
+}
+
+// 记录每一列的结束位置，追加到ends中返回，批量解码时多行共用一个ends
+func (d *delimiterDecoder) decodeInto(row *logRow, line string, ends []int32) ([]int32, error) {
+	begin := len(ends)
+	pos := 0
+	for {
+		var index int
+		if len(d.sep) == 1 {
+			index = strings.IndexByte(line[pos:], d.sep[0])
+		} else {
+			index = strings.Index(line[pos:], d.sep)
+		}
+		if index < 0 {
+			break
+		}
+		ends = append(ends, int32(pos+index))
+		pos += index + len(d.sep)
+	}
+	ends = append(ends, int32(len(line)))
+	*row = logRow{line: line, ends: ends[begin:len(ends):len(ends)], sepLen: len(d.sep)}
+	return ends, nil
+}
+
+func (d *delimiterDecoder) Named() bool {
+	return false
+}
+
+// RFC 4180 csv格式，支持引号包含分隔符和转义
+type csvDecoder struct {
+	comma rune
+}
+
+func (d *csvDecoder) Decode(line string) (*logRow, error) {
+	reader := csv.NewReader(strings.NewReader(line))
+	reader.Comma = d.comma
+	reader.FieldsPerRecord = -1
+	cols, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	return &logRow{line: line, cols: cols}, nil
+}
+
+func (d *csvDecoder) Named() bool {
+	return false
+}
+
+// 每行一个json对象，非字符串的值保留json原文
+type jsonDecoder struct{}
+
+func (d *jsonDecoder) Decode(line string) (*logRow, error) {
+	values := make(map[string]json.RawMessage)
+	decoder := json.NewDecoder(strings.NewReader(line))
+	decoder.UseNumber()
+	if err := decoder.Decode(&values); err != nil {
+		return nil, err
+	}
+	named := make(map[string]string, len(values))
+	for k, raw := range values {
+		raw = bytes.TrimSpace(raw)
+		if len(raw) > 0 && raw[0] == '"' {
+			var str string
+			if err := json.Unmarshal(raw, &str); err != nil {
+				return nil, err
+			}
+			named[k] = str
+			continue
+		}
+		if string(raw) == "null" {
+			named[k] = ""
+			continue
+		}
+		named[k] = string(raw)
+	}
+	return &logRow{line: line, named: named}, nil
+}
+
+func (d *jsonDecoder) Named() bool {
+	return true
+}
+
+// key=value形式
+type kvDecoder struct {
+	pairSep string
+	kvSep   string
+}
+
+func (d *kvDecoder) Decode(line string) (*logRow, error) {
+	var pairs []string
+	if d.pairSep == " " {
+		pairs = strings.Fields(line)
+	} else {
+		pairs = strings.Split(line, d.pairSep)
+	}
+	named := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		if len(pair) == 0 {
+			continue
+		}
+		index := strings.Index(pair, d.kvSep)
+		if index <= 0 {
+			return nil, fmt.Errorf("键值对格式错误[%s]", pair)
+		}
+		named[strings.TrimSpace(pair[:index])] = pair[index+len(d.kvSep):]
+	}
+	return &logRow{line: line, named: named}, nil
+}
+
+func (d *kvDecoder) Named() bool {
+	return true
+}
+
+// 解析日志类型默认解码器配置，格式 tlog=tsv;flog=csv(;)
+func parseLineDecoders(config string) map[string]string {
+	result := make(map[string]string)
+	for _, item := range splitOutsideParens(config, ';') {
+		index := strings.Index(item, "=")
+		if index <= 0 {
+			continue
+		}
+		result[strings.TrimSpace(item[:index])] = strings.TrimSpace(item[index+1:])
+	}
+	return result
+}
+
+// 按照分隔符拆分，忽视括号内的分隔符
+func splitOutsideParens(str string, sep byte) []string {
+	result := make([]string, 0, 2)
+	depth := 0
+	start := 0
+	for i := 0; i < len(str); i++ {
+		switch str[i] {
+		case '(':
+			depth++
+		case ')':
+			if depth > 0 {
+				depth--
+			}
+		case sep:
+			if depth == 0 {
+				result = append(result, str[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(result, str[start:])
+}
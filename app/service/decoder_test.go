@@ -0,0 +1,106 @@
+package service
+
+import (
+	"strings"
+	"testing"
+	"xai.com/shushu/app/model"
+)
+
+func TestDecodeCsv(t *testing.T) {
+	decoder, err := NewLineDecoder("csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	row, err := decoder.Decode(`1,"a,b","say ""hi"""`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := row.ByIndex(2); v != "a,b" {
+		t.Fatal("csv引号内分隔符解析错误", v)
+	}
+	if v, _ := row.ByIndex(3); v != `say "hi"` {
+		t.Fatal("csv转义解析错误", v)
+	}
+}
+
+func TestDecodeDelimiter(t *testing.T) {
+	decoder, _ := NewLineDecoder("delim(|)")
+	row, _ := decoder.Decode("1|2|abc")
+	if v, ok := row.Get(&model.Field{Index: 3}); !ok || v != "abc" {
+		t.Fatal("分隔符解析错误", v)
+	}
+	if _, ok := row.Get(&model.Field{Index: 4}); ok {
+		t.Fatal("下标越界应该返回false")
+	}
+}
+
+func TestDecodeColumnView(t *testing.T) {
+	for _, c := range []struct {
+		spec string
+		line string
+	}{
+		{"tsv", "1\t\t中文\t"},
+		{"delim(||)", "a||||b|c||"},
+		{"tsv", ""},
+	} {
+		decoder, _ := NewLineDecoder(c.spec)
+		sep := "\t"
+		if c.spec != "tsv" {
+			sep = "||"
+		}
+		expected := strings.Split(c.line, sep)
+		rows := decodeLines(c.spec, []string{c.line, c.line}, []int64{0, 10})
+		single, _ := decoder.Decode(c.line)
+		for _, row := range append(rows, single) {
+			for i, col := range expected {
+				if v, ok := row.ByIndex(i + 1); !ok || v != col {
+					t.Fatal(c.spec, "列位置错误", i+1, v, col)
+				}
+			}
+			if _, ok := row.ByIndex(len(expected) + 1); ok {
+				t.Fatal("下标越界应该返回false")
+			}
+		}
+		if rows[1].offset != 10 {
+			t.Fatal("行位置错误", rows[1].offset)
+		}
+	}
+}
+
+func TestDecodeJson(t *testing.T) {
+	decoder, _ := NewLineDecoder("json")
+	row, err := decoder.Decode(`{"account":"acc_1","level":12,"items":[1,2],"empty":null}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := row.Get(&model.Field{Index: 1, Column: "account"}); v != "acc_1" {
+		t.Fatal("json字符串解析错误", v)
+	}
+	if v, _ := row.ByName("level"); v != "12" {
+		t.Fatal("json数值解析错误", v)
+	}
+	if v, _ := row.ByName("items"); v != "[1,2]" {
+		t.Fatal("json数组解析错误", v)
+	}
+}
+
+func TestDecodeKeyValue(t *testing.T) {
+	decoder, _ := NewLineDecoder("kv(&,=)")
+	row, err := decoder.Decode("a=1&b=x=y&c=")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := row.ByName("b"); v != "x=y" {
+		t.Fatal("kv解析错误", v)
+	}
+	if _, err = decoder.Decode("a=1&bad"); err == nil {
+		t.Fatal("kv格式错误应该返回异常")
+	}
+}
+
+func TestParseLineDecoders(t *testing.T) {
+	decoders := parseLineDecoders("tlog=tsv;flog=csv(;)")
+	if decoders["tlog"] != "tsv" || decoders["flog"] != "csv(;)" {
+		t.Fatal("解析日志类型解码器错误", decoders)
+	}
+}
@@ -0,0 +1,292 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	"xai.com/shushu/app/model"
+)
+
+// 断点数据库类型
+type dialect struct {
+	name   string
+	driver string
+	// 是否使用on duplicate key update，否则使用on conflict(id) do update
+	duplicateKey bool
+	// 是否使用$1,$2作为参数占位符
+	numbered bool
+	// 查询列是否存在，参数为表名和列名
+	columnSql string
+	// 查询索引是否存在，参数为表名和索引名
+	indexSql string
+}
+
+var dialects = map[string]*dialect{
+	"mysql": {name: "mysql", driver: "mysql", duplicateKey: true,
+		columnSql: "select count(*) from information_schema.columns where table_schema=database() and table_name=? and column_name=?",
+		indexSql:  "select count(*) from information_schema.statistics where table_schema=database() and table_name=? and index_name=?"},
+	"sqlite": {name: "sqlite", driver: "sqlite3",
+		columnSql: "select count(*) from pragma_table_info(?) where name=?",
+		indexSql:  "select count(*) from sqlite_master where type='index' and tbl_name=? and name=?"},
+	"postgres": {name: "postgres", driver: "postgres", numbered: true,
+		columnSql: "select count(*) from information_schema.columns where table_schema=current_schema() and table_name=? and column_name=?",
+		indexSql:  "select count(*) from pg_indexes where schemaname=current_schema() and tablename=? and indexname=?"},
+}
+
+// 主键为id的表，不存在时插入，存在时更新updates中的列
+func (d *dialect) upsert(table, columns, updates string) string {
+	count := len(strings.Split(columns, ","))
+	sets := make([]string, 0, count)
+	for _, column := range strings.Split(updates, ",") {
+		if d.duplicateKey {
+			sets = append(sets, column+"=values("+column+")")
+		} else {
+			sets = append(sets, column+"=excluded."+column)
+		}
+	}
+	query := "insert into " + table + "(" + columns + ") values(" + strings.TrimSuffix(strings.Repeat("?,", count), ",") + ")"
+	if d.duplicateKey {
+		return query + " on duplicate key update " + strings.Join(sets, ",")
+	}
+	return query + " on conflict(id) do update set " + strings.Join(sets, ",")
+}
+
+// 转换参数占位符
+func (d *dialect) rebind(query string) string {
+	if !d.numbered {
+		return query
+	}
+	builder := strings.Builder{}
+	index := 0
+	for _, c := range query {
+		if c == '?' {
+			index++
+			builder.WriteString("$" + strconv.Itoa(index))
+			continue
+		}
+		builder.WriteRune(c)
+	}
+	return builder.String()
+}
+
+// 数据库连接配置，DatabaseDsn为空时使用mysql配置
+func databaseDsn(config *model.AppConfig, d *dialect) string {
+	if len(config.DatabaseDsn) > 0 {
+		return config.DatabaseDsn
+	}
+	switch d.name {
+	case "mysql":
+		return fmt.Sprintf("%s:%s@%s(%s)/%s?charset=utf8&parseTime=True", config.MysqlUser, config.MysqlPassword, "tcp", config.MysqlAddr, config.MysqlDatabase)
+	case "sqlite":
+		return "log_position.db"
+	}
+	panic(d.name + "数据库必须配置DatabaseDsn")
+}
+
+// 数据库结构版本，只能在末尾增加新版本，已经发布的版本不能修改
+type migration struct {
+	version     int
+	description string
+	statements  map[string][]schemaChange // 数据库类型 -> 语句
+}
+
+// 数据库结构的一条修改，column或index不为空时先检查是否已经存在，已经存在时跳过。
+// mysql的DDL会隐式提交，不能随事务回滚，升级中途失败后重新执行时不能重复执行已经成功的语句
+type schemaChange struct {
+	sql    string
+	table  string
+	column string // 增加的列
+	index  string // 创建的索引
+}
+
+// 使用IF NOT EXISTS的语句，重复执行不会失败
+func createTable(sql string) schemaChange {
+	return schemaChange{sql: sql}
+}
+
+func addColumn(table, column, definition string) schemaChange {
+	return schemaChange{sql: "ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition, table: table, column: column}
+}
+
+func createIndex(table, index, columns string) schemaChange {
+	return schemaChange{sql: "CREATE INDEX " + index + " ON " + table + "(" + columns + ")", table: table, index: index}
+}
+
+// 修改是否已经执行过
+func (d *dialect) applied(ctx context.Context, tx *sql.Tx, change schemaChange) (bool, error) {
+	var query, name string
+	switch {
+	case len(change.column) > 0:
+		query, name = d.columnSql, change.column
+	case len(change.index) > 0:
+		query, name = d.indexSql, change.index
+	default:
+		return false, nil
+	}
+	var count int
+	if err := tx.QueryRowContext(ctx, d.rebind(query), change.table, name).Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+var migrations = []migration{
+	{
+		version:     1,
+		description: "创建log_position表",
+		statements: map[string][]schemaChange{
+			"mysql": {createTable("CREATE TABLE IF NOT EXISTS `log_position` (" +
+				"`id` varchar(255) NOT NULL," +
+				"`operator` int(11) NOT NULL," +
+				"`server` int(11) NOT NULL," +
+				"`log` varchar(255) DEFAULT NULL," +
+				"`type` varchar(255) DEFAULT NULL," +
+				"`last_execute` date DEFAULT NULL," +
+				"`position` bigint(20) NOT NULL," +
+				"`total_rows` int(11) NOT NULL," +
+				"PRIMARY KEY (`id`)" +
+				") ENGINE=InnoDB DEFAULT CHARSET=utf8")},
+			"sqlite": {createTable("CREATE TABLE IF NOT EXISTS log_position (" +
+				"id varchar(255) NOT NULL PRIMARY KEY," +
+				"operator integer NOT NULL," +
+				"server integer NOT NULL," +
+				"log varchar(255) DEFAULT NULL," +
+				"type varchar(255) DEFAULT NULL," +
+				"last_execute date DEFAULT NULL," +
+				"position bigint NOT NULL," +
+				"total_rows integer NOT NULL)")},
+			"postgres": {createTable("CREATE TABLE IF NOT EXISTS log_position (" +
+				"id varchar(255) NOT NULL PRIMARY KEY," +
+				"operator integer NOT NULL," +
+				"server integer NOT NULL," +
+				"log varchar(255) DEFAULT NULL," +
+				"type varchar(255) DEFAULT NULL," +
+				"last_execute date DEFAULT NULL," +
+				"position bigint NOT NULL," +
+				"total_rows integer NOT NULL)")},
+		},
+	},
+	{
+		version:     2,
+		description: "创建file_ledger表,记录每个日志文件的上报结果",
+		statements: map[string][]schemaChange{
+			"mysql":    {createTable(ledgerTableSql + ") ENGINE=InnoDB DEFAULT CHARSET=utf8"), ledgerDayIndex},
+			"sqlite":   {createTable(ledgerTableSql + ")"), ledgerDayIndex},
+			"postgres": {createTable(ledgerTableSql + ")"), ledgerDayIndex},
+		},
+	},
+	{
+		version:     3,
+		description: "file_ledger增加按行统计和对账结果",
+		statements: map[string][]schemaChange{
+			"mysql":    ledgerReconcileSql,
+			"sqlite":   ledgerReconcileSql,
+			"postgres": ledgerReconcileSql,
+		},
+	},
+	{
+		version:     4,
+		description: "创建replay_progress表,记录重新上报的进度",
+		statements: map[string][]schemaChange{
+			"mysql":    replayTableSql,
+			"sqlite":   replayTableSql,
+			"postgres": replayTableSql,
+		},
+	},
+}
+
+var replayTableSql = []schemaChange{createTable("CREATE TABLE IF NOT EXISTS replay_progress (" +
+	"id varchar(255) NOT NULL PRIMARY KEY," +
+	"job varchar(64) NOT NULL," +
+	"position_id varchar(255) NOT NULL," +
+	"file_day varchar(10) NOT NULL," +
+	"position bigint NOT NULL," +
+	"line_count bigint NOT NULL," +
+	"completed integer NOT NULL," +
+	"updated_at varchar(32) NOT NULL)")}
+
+var ledgerDayIndex = createIndex("file_ledger", "idx_file_ledger_day", "file_day")
+
+var ledgerReconcileSql = []schemaChange{
+	addColumn("file_ledger", "acked_lines", "bigint NOT NULL DEFAULT 0"),
+	addColumn("file_ledger", "filtered_lines", "bigint NOT NULL DEFAULT 0"),
+	addColumn("file_ledger", "reconcile", "varchar(255) NOT NULL DEFAULT ''"),
+}
+
+const ledgerTableSql = "CREATE TABLE IF NOT EXISTS file_ledger (" +
+	"id varchar(255) NOT NULL PRIMARY KEY," +
+	"position_id varchar(255) NOT NULL," +
+	"operator integer NOT NULL," +
+	"server integer NOT NULL," +
+	"log varchar(255) NOT NULL," +
+	"sink varchar(64) NOT NULL," +
+	"file_day varchar(10) NOT NULL," +
+	"path varchar(1024) NOT NULL," +
+	"size bigint NOT NULL," +
+	"position bigint NOT NULL," +
+	"line_count bigint NOT NULL," +
+	"rows_sent text NOT NULL," +
+	"rows_dropped bigint NOT NULL," +
+	"first_upload varchar(32) NOT NULL," +
+	"last_upload varchar(32) NOT NULL," +
+	"completed integer NOT NULL"
+
+const schemaVersionSql = "CREATE TABLE IF NOT EXISTS schema_version (" +
+	"version integer NOT NULL PRIMARY KEY," +
+	"description varchar(255) NOT NULL," +
+	"applied_at varchar(32) NOT NULL)"
+
+// 执行没有执行过的版本，返回当前版本
+func migrate(ctx context.Context, db *sql.DB, d *dialect) (int, error) {
+	if _, err := db.ExecContext(ctx, schemaVersionSql); err != nil {
+		return 0, fmt.Errorf("创建schema_version表失败:%s", err.Error())
+	}
+	var current int
+	if err := db.QueryRowContext(ctx, "select coalesce(max(version),0) from schema_version").Scan(&current); err != nil {
+		return 0, fmt.Errorf("查询数据库版本失败:%s", err.Error())
+	}
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		statements, ok := m.statements[d.name]
+		if !ok {
+			return current, fmt.Errorf("数据库版本%d没有%s语句", m.version, d.name)
+		}
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return current, err
+		}
+		for _, change := range statements {
+			applied, err := d.applied(ctx, tx, change)
+			if err != nil {
+				_ = tx.Rollback()
+				return current, fmt.Errorf("数据库版本%d检查失败:%s", m.version, err.Error())
+			}
+			if applied {
+				log.Println("数据库版本", m.version, "已经执行过,跳过", change.sql)
+				continue
+			}
+			if _, err := tx.ExecContext(ctx, change.sql); err != nil {
+				_ = tx.Rollback()
+				return current, fmt.Errorf("数据库版本%d执行失败:%s", m.version, err.Error())
+			}
+		}
+		_, err = tx.ExecContext(ctx, d.rebind("insert into schema_version(version,description,applied_at) values(?,?,?)"),
+			m.version, m.description, time.Now().Format("2006-01-02 15:04:05"))
+		if err != nil {
+			_ = tx.Rollback()
+			return current, fmt.Errorf("记录数据库版本%d失败:%s", m.version, err.Error())
+		}
+		if err := tx.Commit(); err != nil {
+			return current, err
+		}
+		log.Println("数据库升级到版本", m.version, m.description)
+		current = m.version
+	}
+	return current, nil
+}
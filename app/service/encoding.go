@@ -0,0 +1,91 @@
+package service
+
+import (
+	"fmt"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+	"strings"
+	"unicode/utf8"
+	"xai.com/shushu/app/model"
+)
+
+// 支持的日志文件编码，都兼容ASCII，\r和\n不会出现在多字节字符中，可以先切分记录再转换
+var sourceEncodings = map[string]encoding.Encoding{
+	"utf-8":   nil,
+	"utf8":    nil,
+	"gbk":     simplifiedchinese.GBK,
+	"gb2312":  simplifiedchinese.GBK,
+	"gb18030": simplifiedchinese.GB18030,
+	"big5":    traditionalchinese.Big5,
+	"latin1":  charmap.ISO8859_1,
+}
+
+// 日志文件格式，记录分割方式和字符编码
+type fileFormat struct {
+	framer   recordFramer
+	encoding encoding.Encoding // 为nil时是utf-8
+}
+
+// 日志文件格式，日志名配置优先于日志类型配置
+func fileFormatFor(config *model.AppConfig, recordName, logType string) *fileFormat {
+	return &fileFormat{
+		framer:   framerFor(config, recordName, logType),
+		encoding: encodingFor(config, recordName, logType),
+	}
+}
+
+// 日志文件编码，格式 tlog=gbk;ItemRecord=gb18030，默认utf-8
+func encodingFor(config *model.AppConfig, recordName, logType string) encoding.Encoding {
+	encodings := parseLineDecoders(config.SourceEncoding)
+	name, ok := encodings[recordName]
+	if !ok {
+		name = encodings[logType]
+	}
+	if len(name) == 0 {
+		return nil
+	}
+	enc, ok := sourceEncodings[strings.ToLower(name)]
+	if !ok {
+		panic(fmt.Sprintf("SourceEncoding配置错误%s:不支持的编码[%s]", recordName, name))
+	}
+	return enc
+}
+
+// 将一条记录转换为utf-8字符串，无效的字节替换为�。
+// 返回的函数复用同一个解码器，不能并发调用，每次扫描文件创建一个
+func (f *fileFormat) transcoder() func(record []byte) string {
+	if f.encoding == nil {
+		return func(record []byte) string {
+			if utf8.Valid(record) {
+				return string(record)
+			}
+			return strings.ToValidUTF8(string(record), string(utf8.RuneError))
+		}
+	}
+	decoder := f.encoding.NewDecoder()
+	return func(record []byte) string {
+		decoder.Reset()
+		result, err := decoder.Bytes(record)
+		if err != nil {
+			return strings.ToValidUTF8(string(record), string(utf8.RuneError))
+		}
+		return string(result)
+	}
+}
+
+// 按照字符数截断字符串，不会截断多字节字符
+func truncateRunes(str string, max int) string {
+	if len(str) <= max {
+		return str
+	}
+	count := 0
+	for i := range str {
+		if count == max {
+			return str[:i]
+		}
+		count++
+	}
+	return str
+}
@@ -0,0 +1,77 @@
+package service
+
+import (
+	"context"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+	"xai.com/shushu/app/model"
+)
+
+func TestSourceEncoding(t *testing.T) {
+	gbk, err := simplifiedchinese.GBK.NewEncoder().String("1\t玩家聊天\n2\t你好|世界\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "encoding")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	path := filepath.Join(dir, "1_1_ChatRecord.2021-05-02")
+	if err := ioutil.WriteFile(path, []byte(gbk), 0644); err != nil {
+		t.Fatal(err)
+	}
+	format := fileFormatFor(&model.AppConfig{SourceEncoding: "tlog=gbk"}, "ChatRecord", "tlog")
+	records := make([]string, 0)
+	var position int64
+	err = scanFile(context.Background(), path, 0, format, false, func(pos int64, lines []string, offsets []int64) error {
+		records = append(records, lines...)
+		position = pos
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(records, []string{"1\t玩家聊天", "2\t你好|世界"}) || position != int64(len(gbk)) {
+		t.Fatal("gbk转换错误", records, position)
+	}
+}
+
+func TestTranscodeInvalid(t *testing.T) {
+	transcode := (&fileFormat{}).transcoder()
+	if result := transcode([]byte("ok\xffend")); result != "ok�end" {
+		t.Fatal("无效的utf-8字节应该替换", result)
+	}
+	transcode = (&fileFormat{encoding: simplifiedchinese.GB18030}).transcoder()
+	if result := transcode([]byte("a\x81")); !utf8.ValidString(result) || !strings.HasPrefix(result, "a") {
+		t.Fatal("不完整的gb18030字符应该替换", result)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatal("不支持的编码应该panic")
+		}
+	}()
+	encodingFor(&model.AppConfig{SourceEncoding: "tlog=utf-16"}, "ItemRecord", "tlog")
+}
+
+func TestTruncateRunes(t *testing.T) {
+	if result := truncateRunes(strings.Repeat("中", 1100), maxStringLength); utf8.RuneCountInString(result) != maxStringLength || !utf8.ValidString(result) {
+		t.Fatal("应该按照字符截断", utf8.RuneCountInString(result))
+	}
+	if result := truncateRunes("abc", 2); result != "ab" {
+		t.Fatal(result)
+	}
+	if result := truncateRunes("中文", 2); result != "中文" {
+		t.Fatal(result)
+	}
+	value, err := convertValue(&model.Field{Kind: "string"}, strings.Repeat("a", 1020)+"中文字符串")
+	if err != nil || utf8.RuneCountInString(value.(string)) != maxStringLength {
+		t.Fatal("字符串字段截断错误", value, err)
+	}
+}
@@ -0,0 +1,799 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// 表达式计算上下文
+type exprContext struct {
+	row    *logRow    // 当前日志行,col()使用
+	values *uploadRow // 已经解析的字段,prop()使用
+}
+
+// 编译后的表达式
+type expression struct {
+	source string
+	root   exprNode
+}
+
+// 计算表达式，结果为string,float64,bool或者nil
+func (e *expression) Eval(ctx *exprContext) (interface{}, error) {
+	return e.root.eval(ctx)
+}
+
+// 计算表达式并转换为字符串，nil转换为空字符串
+func (e *expression) EvalString(ctx *exprContext) (string, error) {
+	value, err := e.Eval(ctx)
+	if err != nil {
+		return "", err
+	}
+	return toString(value), nil
+}
+
+// 计算表达式并转换为bool
+func (e *expression) EvalBool(ctx *exprContext) (bool, error) {
+	value, err := e.Eval(ctx)
+	if err != nil {
+		return false, err
+	}
+	return toBool(value), nil
+}
+
+var expressionCache sync.Map
+
+// 编译表达式，相同的表达式只编译一次
+//
+// 支持:
+// 常量 123, 1.5, "abc", 'abc', true, false, null
+// 列引用 $3 (下标), $name (列名), col(3), col("name")
+// 已解析字段 prop("#account_id")
+// 运算 + - * / % == != < <= > >= && || ! ()
+// 函数 concat,substr,len,upper,lower,trim,replace,split,index,last_index,before_last,after_last,
+// regex,match,in,if,coalesce,int,float,str
+func compileExpression(source string) (*expression, error) {
+	if cache, ok := expressionCache.Load(source); ok {
+		return cache.(*expression), nil
+	}
+	parser := &exprParser{lexer: &exprLexer{input: source}}
+	if err := parser.next(); err != nil {
+		return nil, fmt.Errorf("表达式[%s]错误:%s", source, err.Error())
+	}
+	root, err := parser.parseExpr(0)
+	if err != nil {
+		return nil, fmt.Errorf("表达式[%s]错误:%s", source, err.Error())
+	}
+	if parser.token.kind != tokenEOF {
+		return nil, fmt.Errorf("表达式[%s]错误:多余的内容[%s]", source, parser.token.text)
+	}
+	expr := &expression{source: source, root: root}
+	actual, _ := expressionCache.LoadOrStore(source, expr)
+	return actual.(*expression), nil
+}
+
+// 获取已经编译的表达式，配置加载时已经校验过
+func mustExpression(source string) *expression {
+	expr, err := compileExpression(source)
+	if err != nil {
+		panic(err.Error())
+	}
+	return expr
+}
+
+const (
+	tokenEOF = iota
+	tokenNumber
+	tokenString
+	tokenIdent
+	tokenColumn
+	tokenOperator
+)
+
+type exprToken struct {
+	kind int
+	text string
+}
+
+type exprLexer struct {
+	input string
+	pos   int
+}
+
+func (l *exprLexer) next() (exprToken, error) {
+	for l.pos < len(l.input) && (l.input[l.pos] == ' ' || l.input[l.pos] == '\t') {
+		l.pos++
+	}
+	if l.pos >= len(l.input) {
+		return exprToken{kind: tokenEOF}, nil
+	}
+	start := l.pos
+	c := l.input[l.pos]
+	switch {
+	case c >= '0' && c <= '9':
+		for l.pos < len(l.input) && (isDigit(l.input[l.pos]) || l.input[l.pos] == '.') {
+			l.pos++
+		}
+		return exprToken{kind: tokenNumber, text: l.input[start:l.pos]}, nil
+	case c == '"' || c == '\'':
+		l.pos++
+		var sb strings.Builder
+		for l.pos < len(l.input) {
+			ch := l.input[l.pos]
+			// 只转义引号和反斜杠，其他反斜杠保留(正则使用)
+			if ch == '\\' && l.pos+1 < len(l.input) && strings.IndexByte("\\\"'", l.input[l.pos+1]) >= 0 {
+				sb.WriteByte(l.input[l.pos+1])
+				l.pos += 2
+				continue
+			}
+			if ch == c {
+				l.pos++
+				return exprToken{kind: tokenString, text: sb.String()}, nil
+			}
+			sb.WriteByte(ch)
+			l.pos++
+		}
+		return exprToken{}, errors.New("字符串没有结束")
+	case c == '$':
+		l.pos++
+		for l.pos < len(l.input) && isIdentByte(l.input[l.pos]) {
+			l.pos++
+		}
+		if l.pos == start+1 {
+			return exprToken{}, errors.New("$后缺少列下标或者列名")
+		}
+		return exprToken{kind: tokenColumn, text: l.input[start+1 : l.pos]}, nil
+	case isIdentByte(c):
+		for l.pos < len(l.input) && isIdentByte(l.input[l.pos]) {
+			l.pos++
+		}
+		return exprToken{kind: tokenIdent, text: l.input[start:l.pos]}, nil
+	}
+	for _, op := range []string{"==", "!=", "<=", ">=", "&&", "||"} {
+		if strings.HasPrefix(l.input[l.pos:], op) {
+			l.pos += 2
+			return exprToken{kind: tokenOperator, text: op}, nil
+		}
+	}
+	if strings.ContainsRune("+-*/%<>!(),", rune(c)) {
+		l.pos++
+		return exprToken{kind: tokenOperator, text: string(c)}, nil
+	}
+	return exprToken{}, fmt.Errorf("无法识别的字符[%c]", c)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c == '#' || isDigit(c) || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// 二元运算符优先级
+var binaryPrecedence = map[string]int{
+	"||": 1,
+	"&&": 2,
+	"==": 3, "!=": 3,
+	"<": 4, "<=": 4, ">": 4, ">=": 4,
+	"+": 5, "-": 5,
+	"*": 6, "/": 6, "%": 6,
+}
+
+type exprParser struct {
+	lexer *exprLexer
+	token exprToken
+}
+
+func (p *exprParser) next() error {
+	token, err := p.lexer.next()
+	if err != nil {
+		return err
+	}
+	p.token = token
+	return nil
+}
+
+func (p *exprParser) expect(op string) error {
+	if p.token.kind != tokenOperator || p.token.text != op {
+		return fmt.Errorf("缺少[%s]", op)
+	}
+	return p.next()
+}
+
+func (p *exprParser) parseExpr(minPrecedence int) (exprNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.token.kind == tokenOperator {
+		op := p.token.text
+		precedence, ok := binaryPrecedence[op]
+		if !ok || precedence <= minPrecedence {
+			break
+		}
+		if err = p.next(); err != nil {
+			return nil, err
+		}
+		right, err := p.parseExpr(precedence)
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	if p.token.kind == tokenOperator && (p.token.text == "!" || p.token.text == "-") {
+		op := p.token.text
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryNode{op: op, operand: operand}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	token := p.token
+	switch token.kind {
+	case tokenNumber:
+		number, err := strconv.ParseFloat(token.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("数字格式错误[%s]", token.text)
+		}
+		// float64无法精确表示的整数(如19位账号)保留原文，比较时按照整数比较
+		if _, ok := exactInteger(number); !ok {
+			if _, err := strconv.ParseInt(token.text, 10, 64); err == nil {
+				return &constNode{value: token.text}, p.next()
+			}
+		}
+		return &constNode{value: number}, p.next()
+	case tokenString:
+		return &constNode{value: token.text}, p.next()
+	case tokenColumn:
+		if index, err := strconv.Atoi(token.text); err == nil {
+			return &columnNode{index: index}, p.next()
+		}
+		return &columnNode{name: token.text}, p.next()
+	case tokenIdent:
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		switch token.text {
+		case "true":
+			return &constNode{value: true}, nil
+		case "false":
+			return &constNode{value: false}, nil
+		case "null":
+			return &constNode{value: nil}, nil
+		}
+		return p.parseCall(token.text)
+	case tokenOperator:
+		if token.text == "(" {
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			node, err := p.parseExpr(0)
+			if err != nil {
+				return nil, err
+			}
+			return node, p.expect(")")
+		}
+	case tokenEOF:
+		return nil, errors.New("表达式不完整")
+	}
+	return nil, fmt.Errorf("无法识别[%s]", token.text)
+}
+
+func (p *exprParser) parseCall(name string) (exprNode, error) {
+	function, ok := exprFunctions[name]
+	if !ok {
+		return nil, fmt.Errorf("不支持的函数[%s]", name)
+	}
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	args := make([]exprNode, 0, 2)
+	for !(p.token.kind == tokenOperator && p.token.text == ")") {
+		if len(args) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		arg, err := p.parseExpr(0)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	if len(args) < function.minArgs || (function.maxArgs >= 0 && len(args) > function.maxArgs) {
+		return nil, fmt.Errorf("函数[%s]参数数量错误", name)
+	}
+	node := &callNode{name: name, function: function, args: args}
+	// 正则在编译阶段预编译
+	if function.regexArg > 0 {
+		if pattern, ok := args[function.regexArg-1].(*constNode); ok {
+			re, err := regexp.Compile(toString(pattern.value))
+			if err != nil {
+				return nil, fmt.Errorf("函数[%s]正则错误:%s", name, err.Error())
+			}
+			node.regex = re
+		}
+	}
+	return node, nil
+}
+
+type exprNode interface {
+	eval(ctx *exprContext) (interface{}, error)
+}
+
+type constNode struct {
+	value interface{}
+}
+
+func (n *constNode) eval(*exprContext) (interface{}, error) {
+	return n.value, nil
+}
+
+type columnNode struct {
+	index int
+	name  string
+}
+
+func (n *columnNode) eval(ctx *exprContext) (interface{}, error) {
+	return columnValue(ctx, n.index, n.name)
+}
+
+func columnValue(ctx *exprContext, index int, name string) (interface{}, error) {
+	if ctx == nil || ctx.row == nil {
+		return nil, errors.New("当前上下文没有日志行")
+	}
+	var value string
+	var ok bool
+	if len(name) > 0 {
+		value, ok = ctx.row.ByName(name)
+	} else {
+		value, ok = ctx.row.ByIndex(index)
+	}
+	if !ok {
+		return nil, nil
+	}
+	return value, nil
+}
+
+type unaryNode struct {
+	op      string
+	operand exprNode
+}
+
+func (n *unaryNode) eval(ctx *exprContext) (interface{}, error) {
+	value, err := n.operand.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if n.op == "!" {
+		return !toBool(value), nil
+	}
+	number, err := toNumber(value)
+	if err != nil {
+		return nil, err
+	}
+	return -number, nil
+}
+
+type binaryNode struct {
+	op    string
+	left  exprNode
+	right exprNode
+}
+
+func (n *binaryNode) eval(ctx *exprContext) (interface{}, error) {
+	left, err := n.left.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// 短路计算
+	switch n.op {
+	case "&&":
+		if !toBool(left) {
+			return false, nil
+		}
+		right, err := n.right.eval(ctx)
+		return toBool(right), err
+	case "||":
+		if toBool(left) {
+			return true, nil
+		}
+		right, err := n.right.eval(ctx)
+		return toBool(right), err
+	}
+	right, err := n.right.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "==":
+		return compareValues(left, right) == 0, nil
+	case "!=":
+		return compareValues(left, right) != 0, nil
+	case "<":
+		return compareValues(left, right) < 0, nil
+	case "<=":
+		return compareValues(left, right) <= 0, nil
+	case ">":
+		return compareValues(left, right) > 0, nil
+	case ">=":
+		return compareValues(left, right) >= 0, nil
+	}
+	// 任意一边为非数字字符串时+为字符串拼接
+	if n.op == "+" {
+		if _, ok := left.(string); ok {
+			if _, err := toNumber(left); err != nil {
+				return toString(left) + toString(right), nil
+			}
+		}
+		if _, ok := right.(string); ok {
+			if _, err := toNumber(right); err != nil {
+				return toString(left) + toString(right), nil
+			}
+		}
+	}
+	a, err := toNumber(left)
+	if err != nil {
+		return nil, err
+	}
+	b, err := toNumber(right)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "+":
+		return a + b, nil
+	case "-":
+		return a - b, nil
+	case "*":
+		return a * b, nil
+	case "/":
+		if b == 0 {
+			return nil, errors.New("除数为0")
+		}
+		return a / b, nil
+	case "%":
+		if b == 0 {
+			return nil, errors.New("除数为0")
+		}
+		return math.Mod(a, b), nil
+	}
+	return nil, fmt.Errorf("不支持的运算符[%s]", n.op)
+}
+
+type exprFunction struct {
+	minArgs  int
+	maxArgs  int // -1不限制
+	regexArg int // 正则参数的位置(从1开始)，0没有正则参数
+	call     func(ctx *exprContext, node *callNode, args []interface{}) (interface{}, error)
+}
+
+type callNode struct {
+	name     string
+	function *exprFunction
+	args     []exprNode
+	regex    *regexp.Regexp
+}
+
+func (n *callNode) eval(ctx *exprContext) (interface{}, error) {
+	// if和coalesce只计算需要的参数
+	switch n.name {
+	case "if":
+		cond, err := n.args[0].eval(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if toBool(cond) {
+			return n.args[1].eval(ctx)
+		}
+		return n.args[2].eval(ctx)
+	case "coalesce":
+		for _, arg := range n.args {
+			value, err := arg.eval(ctx)
+			if err != nil {
+				return nil, err
+			}
+			if value != nil && toString(value) != "" {
+				return value, nil
+			}
+		}
+		return nil, nil
+	}
+	args := make([]interface{}, len(n.args))
+	for i, arg := range n.args {
+		value, err := arg.eval(ctx)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = value
+	}
+	return n.function.call(ctx, n, args)
+}
+
+// 获取正则，常量正则使用预编译结果
+func (n *callNode) regexOf(pattern interface{}) (*regexp.Regexp, error) {
+	if n.regex != nil {
+		return n.regex, nil
+	}
+	return regexp.Compile(toString(pattern))
+}
+
+var exprFunctions map[string]*exprFunction
+
+func init() {
+	exprFunctions = map[string]*exprFunction{
+		"col": {minArgs: 1, maxArgs: 1, call: func(ctx *exprContext, _ *callNode, args []interface{}) (interface{}, error) {
+			if number, err := toNumber(args[0]); err == nil {
+				return columnValue(ctx, int(number), "")
+			}
+			return columnValue(ctx, 0, toString(args[0]))
+		}},
+		"prop": {minArgs: 1, maxArgs: 1, call: func(ctx *exprContext, _ *callNode, args []interface{}) (interface{}, error) {
+			if ctx == nil || ctx.values == nil {
+				return nil, nil
+			}
+			value, _ := ctx.values.value(toString(args[0]))
+			return value, nil
+		}},
+		"concat": {minArgs: 1, maxArgs: -1, call: func(_ *exprContext, _ *callNode, args []interface{}) (interface{}, error) {
+			var sb strings.Builder
+			for _, arg := range args {
+				sb.WriteString(toString(arg))
+			}
+			return sb.String(), nil
+		}},
+		"substr": {minArgs: 2, maxArgs: 3, call: func(_ *exprContext, _ *callNode, args []interface{}) (interface{}, error) {
+			runes := []rune(toString(args[0]))
+			start, err := toNumber(args[1])
+			if err != nil {
+				return nil, err
+			}
+			begin := clampIndex(int(start), len(runes))
+			end := len(runes)
+			if len(args) == 3 {
+				length, err := toNumber(args[2])
+				if err != nil {
+					return nil, err
+				}
+				end = clampIndex(begin+int(length), len(runes))
+			}
+			return string(runes[begin:end]), nil
+		}},
+		"len": {minArgs: 1, maxArgs: 1, call: func(_ *exprContext, _ *callNode, args []interface{}) (interface{}, error) {
+			return float64(utf8.RuneCountInString(toString(args[0]))), nil
+		}},
+		"upper": {minArgs: 1, maxArgs: 1, call: func(_ *exprContext, _ *callNode, args []interface{}) (interface{}, error) {
+			return strings.ToUpper(toString(args[0])), nil
+		}},
+		"lower": {minArgs: 1, maxArgs: 1, call: func(_ *exprContext, _ *callNode, args []interface{}) (interface{}, error) {
+			return strings.ToLower(toString(args[0])), nil
+		}},
+		"trim": {minArgs: 1, maxArgs: 1, call: func(_ *exprContext, _ *callNode, args []interface{}) (interface{}, error) {
+			return strings.TrimFunc(toString(args[0]), unicode.IsSpace), nil
+		}},
+		"replace": {minArgs: 3, maxArgs: 3, call: func(_ *exprContext, _ *callNode, args []interface{}) (interface{}, error) {
+			return strings.ReplaceAll(toString(args[0]), toString(args[1]), toString(args[2])), nil
+		}},
+		"split": {minArgs: 3, maxArgs: 3, call: func(_ *exprContext, _ *callNode, args []interface{}) (interface{}, error) {
+			parts := strings.Split(toString(args[0]), toString(args[1]))
+			index, err := toNumber(args[2])
+			if err != nil {
+				return nil, err
+			}
+			if int(index) < 0 || int(index) >= len(parts) {
+				return nil, nil
+			}
+			return parts[int(index)], nil
+		}},
+		"index": {minArgs: 2, maxArgs: 2, call: func(_ *exprContext, _ *callNode, args []interface{}) (interface{}, error) {
+			return float64(strings.Index(toString(args[0]), toString(args[1]))), nil
+		}},
+		"last_index": {minArgs: 2, maxArgs: 2, call: func(_ *exprContext, _ *callNode, args []interface{}) (interface{}, error) {
+			return float64(strings.LastIndex(toString(args[0]), toString(args[1]))), nil
+		}},
+		"before_last": {minArgs: 2, maxArgs: 2, call: func(_ *exprContext, _ *callNode, args []interface{}) (interface{}, error) {
+			str := toString(args[0])
+			if index := strings.LastIndex(str, toString(args[1])); index >= 0 {
+				return str[:index], nil
+			}
+			return str, nil
+		}},
+		"after_last": {minArgs: 2, maxArgs: 2, call: func(_ *exprContext, _ *callNode, args []interface{}) (interface{}, error) {
+			str, sep := toString(args[0]), toString(args[1])
+			if index := strings.LastIndex(str, sep); index >= 0 {
+				return str[index+len(sep):], nil
+			}
+			return str, nil
+		}},
+		"regex": {minArgs: 2, maxArgs: 3, regexArg: 2, call: func(_ *exprContext, node *callNode, args []interface{}) (interface{}, error) {
+			re, err := node.regexOf(args[1])
+			if err != nil {
+				return nil, err
+			}
+			group := 0
+			if len(args) == 3 {
+				number, err := toNumber(args[2])
+				if err != nil {
+					return nil, err
+				}
+				group = int(number)
+			}
+			match := re.FindStringSubmatch(toString(args[0]))
+			if group < 0 || group >= len(match) {
+				return nil, nil
+			}
+			return match[group], nil
+		}},
+		"match": {minArgs: 2, maxArgs: 2, regexArg: 2, call: func(_ *exprContext, node *callNode, args []interface{}) (interface{}, error) {
+			re, err := node.regexOf(args[1])
+			if err != nil {
+				return nil, err
+			}
+			return re.MatchString(toString(args[0])), nil
+		}},
+		"in": {minArgs: 2, maxArgs: -1, call: func(_ *exprContext, _ *callNode, args []interface{}) (interface{}, error) {
+			for _, arg := range args[1:] {
+				if compareValues(args[0], arg) == 0 {
+					return true, nil
+				}
+			}
+			return false, nil
+		}},
+		"if":       {minArgs: 3, maxArgs: 3},
+		"coalesce": {minArgs: 1, maxArgs: -1},
+		"int": {minArgs: 1, maxArgs: 1, call: func(_ *exprContext, _ *callNode, args []interface{}) (interface{}, error) {
+			number, err := toNumber(args[0])
+			if err != nil {
+				return nil, err
+			}
+			return math.Trunc(number), nil
+		}},
+		"float": {minArgs: 1, maxArgs: 1, call: func(_ *exprContext, _ *callNode, args []interface{}) (interface{}, error) {
+			return toNumber(args[0])
+		}},
+		"str": {minArgs: 1, maxArgs: 1, call: func(_ *exprContext, _ *callNode, args []interface{}) (interface{}, error) {
+			return toString(args[0]), nil
+		}},
+	}
+}
+
+func clampIndex(index, length int) int {
+	if index < 0 {
+		return 0
+	}
+	if index > length {
+		return length
+	}
+	return index
+}
+
+func toString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return fmt.Sprint(value)
+}
+
+func toNumber(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case nil:
+		return 0, nil
+	case float64:
+		return v, nil
+	case int:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case bool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	}
+	str := strings.TrimSpace(toString(value))
+	if len(str) == 0 {
+		return 0, nil
+	}
+	number, err := strconv.ParseFloat(str, 64)
+	if err != nil {
+		return 0, fmt.Errorf("[%s]不是数字", str)
+	}
+	return number, nil
+}
+
+func toBool(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	case string:
+		return len(v) > 0 && v != "false" && v != "0"
+	}
+	return true
+}
+
+// float64可以精确表示的整数范围
+const maxExactInteger = 1 << 53
+
+// 整数值，float64只有在精确表示的范围内的整数才转换
+func exactInteger(number float64) (int64, bool) {
+	if number != math.Trunc(number) || math.Abs(number) > maxExactInteger {
+		return 0, false
+	}
+	return int64(number), true
+}
+
+// 转换为整数，小数和非数字返回false
+func toInteger(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case nil, bool:
+		return 0, false
+	case int:
+		return int64(v), true
+	case int64:
+		return v, true
+	case float64:
+		return exactInteger(v)
+	}
+	number, err := strconv.ParseInt(strings.TrimSpace(toString(value)), 10, 64)
+	return number, err == nil
+}
+
+// 两边都可以转换为数字时按照数字比较，否则按照字符串比较。
+// 两边都是整数时按照int64比较，超过2^53的账号id转换为float64后会相等
+func compareValues(left, right interface{}) int {
+	if a, ok := toInteger(left); ok {
+		if b, ok := toInteger(right); ok {
+			switch {
+			case a < b:
+				return -1
+			case a > b:
+				return 1
+			}
+			return 0
+		}
+	}
+	a, errA := toNumber(left)
+	b, errB := toNumber(right)
+	if errA == nil && errB == nil && toString(left) != "" && toString(right) != "" {
+		switch {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(toString(left), toString(right))
+}
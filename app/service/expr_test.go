@@ -0,0 +1,76 @@
+package service
+
+import (
+	"testing"
+)
+
+func evalTest(t *testing.T, source string, ctx *exprContext) interface{} {
+	expr, err := compileExpression(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, err := expr.Eval(ctx)
+	if err != nil {
+		t.Fatal(source, err)
+	}
+	return value
+}
+
+func TestExpressionColumns(t *testing.T) {
+	decoder, _ := NewLineDecoder("tsv")
+	row, _ := decoder.Decode("1\t12\trobot-2-61.1_1\t100")
+	ctx := &exprContext{row: row}
+	if v := evalTest(t, `concat($1, "_", col(2))`, ctx); v != "1_12" {
+		t.Fatal("concat错误", v)
+	}
+	if v := evalTest(t, `$4 * 2 + 1`, ctx); v != 201.0 {
+		t.Fatal("算术运算错误", v)
+	}
+	if v := evalTest(t, `regex($3, "robot-(\d+)", 1)`, ctx); v != "2" {
+		t.Fatal("正则提取错误", v)
+	}
+	if v := evalTest(t, `if($4 >= 100 && !($1 == 2), "big", "small")`, ctx); v != "big" {
+		t.Fatal("条件运算错误", v)
+	}
+	if v := evalTest(t, `substr($3, 0, 5)`, ctx); v != "robot" {
+		t.Fatal("substr错误", v)
+	}
+	if v := evalTest(t, `in($2, 11, 12, 13)`, ctx); v != true {
+		t.Fatal("in错误", v)
+	}
+	if v := evalTest(t, `coalesce($9, "default")`, ctx); v != "default" {
+		t.Fatal("coalesce错误", v)
+	}
+}
+
+func TestExpressionCompileError(t *testing.T) {
+	for _, source := range []string{`concat($1`, `unknown(1)`, `"abc`, `1 +`, `substr("a")`, `match($1, "(")`} {
+		if _, err := compileExpression(source); err == nil {
+			t.Fatal(source, "应该编译失败")
+		}
+	}
+}
+
+// 默认配置的userId表达式与原有的去后缀规则一致
+func TestCompareLargeIntegers(t *testing.T) {
+	decoder, _ := NewLineDecoder("tsv")
+	row, _ := decoder.Decode("1234567890123456781\t1234567890123456782\t1234567890123456781")
+	ctx := &exprContext{row: row}
+	for source, expect := range map[string]bool{
+		`$1 == $2`:                  false,
+		`$1 == $3`:                  true,
+		`$1 < $2`:                   true,
+		`$1 == 1234567890123456782`: false,
+		`$1 == 1234567890123456781`: true,
+		`in($1, "1234567890123456782", 1234567890123456782)`: false,
+		`in($3, 1234567890123456780, 1234567890123456781)`:   true,
+		`"1.5" < 2`: true,
+	} {
+		if v := evalTest(t, source, ctx); v != expect {
+			t.Fatal(source, "大整数比较错误", v)
+		}
+	}
+	if compareValues(float64(3), "3") != 0 || compareValues("10", "9") != 1 {
+		t.Fatal("整数比较错误")
+	}
+}
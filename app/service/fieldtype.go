@@ -0,0 +1,186 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"xai.com/shushu/app/model"
+)
+
+// 字符串类型最大长度(字符数)
+const maxStringLength = 1024
+
+// 编译字段类型配置，支持:
+// string,int,float,bool,json
+// [I,[S,[F 整数/字符串/浮点数列表
+// date 毫秒时间戳, date_s 秒时间戳, date(2006-01-02 15:04:05|Asia/Shanghai) 格式化时间
+// enum(ItemName) 按照映射表转换
+func compileFieldType(field *model.Field, enums map[string]map[string]string) error {
+	dataType := strings.TrimSpace(field.DataType)
+	name, args := dataType, ""
+	if start := strings.Index(dataType, "("); start > 0 && strings.HasSuffix(dataType, ")") {
+		name, args = dataType[:start], dataType[start+1:len(dataType)-1]
+	}
+	field.Kind = name
+	switch name {
+	case "string", "int", "float", "bool", "json", "[I", "[S", "[F", "date_s":
+		if len(args) > 0 {
+			return fmt.Errorf("字段类型[%s]不支持参数", dataType)
+		}
+	case "date":
+		if len(args) == 0 {
+			return nil
+		}
+		layout := args
+		if index := strings.LastIndex(args, "|"); index >= 0 {
+			layout = args[:index]
+			location, err := time.LoadLocation(strings.TrimSpace(args[index+1:]))
+			if err != nil {
+				return fmt.Errorf("字段类型[%s]时区错误:%s", dataType, err.Error())
+			}
+			field.Location = location
+		}
+		if len(layout) == 0 {
+			return fmt.Errorf("字段类型[%s]缺少时间格式", dataType)
+		}
+		field.Layout = layout
+	case "enum":
+		mapping, ok := enums[args]
+		if !ok {
+			return fmt.Errorf("字段类型[%s]对应的映射[%s]不存在", dataType, args)
+		}
+		field.Mapping = mapping
+	default:
+		return fmt.Errorf("不支持的字段类型[%s]", dataType)
+	}
+	return nil
+}
+
+// 是否为日期类型
+func isDateKind(kind string) bool {
+	return kind == "date" || kind == "date_s"
+}
+
+// 是否为数值类型
+func isNumberKind(kind string) bool {
+	return kind == "int" || kind == "float"
+}
+
+// 是否为列表类型
+func isListKind(kind string) bool {
+	return kind == "[I" || kind == "[S" || kind == "[F"
+}
+
+// 解析日期类型字段，格式化日期没有配置时区时使用服务器时区
+func parseDate(field *model.Field, strValue string, serverLocation *time.Location) (time.Time, error) {
+	if len(field.Layout) > 0 {
+		location := field.Location
+		if location == nil {
+			location = locationOrLocal(serverLocation)
+		}
+		return time.ParseInLocation(field.Layout, strValue, location)
+	}
+	number, err := strconv.ParseInt(strValue, 10, 64)
+	if err != nil {
+		return time.Unix(0, 0), err
+	}
+	if field.Kind == "date_s" {
+		return time.Unix(number, 0), nil
+	}
+	return time.Unix(0, int64(time.Duration(number)*time.Millisecond)), nil
+}
+
+// 按照字段类型转换非日期字段，失败时返回类型零值和错误
+func convertValue(field *model.Field, strValue string) (interface{}, error) {
+	switch field.Kind {
+	case "string":
+		return truncateRunes(strValue, maxStringLength), nil
+	case "int":
+		value, err := strconv.ParseInt(strValue, 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		return value, nil
+	case "float":
+		value, err := strconv.ParseFloat(strValue, 64)
+		if err != nil {
+			return 0.0, err
+		}
+		return value, nil
+	case "bool":
+		value, _ := strconv.ParseBool(strValue)
+		return value, nil
+	case "json":
+		value := make(map[string]interface{})
+		err := json.Unmarshal([]byte(strValue), &value)
+		return value, err
+	case "[I":
+		array := make([]int, 0, 3)
+		err := json.Unmarshal([]byte(strValue), &array)
+		return array, err
+	case "[F":
+		array := make([]float64, 0, 3)
+		err := json.Unmarshal([]byte(strValue), &array)
+		return array, err
+	case "[S":
+		return parseStringList(strValue)
+	case "enum":
+		if value, ok := field.Mapping[strValue]; ok {
+			return value, nil
+		}
+		return strValue, fmt.Errorf("映射中不存在[%s]", strValue)
+	}
+	return nil, fmt.Errorf("不支持的字段类型[%s]", field.DataType)
+}
+
+// 字符串列表支持json数组或者逗号分隔
+func parseStringList(strValue string) ([]string, error) {
+	trimValue := strings.TrimSpace(strValue)
+	if len(trimValue) == 0 {
+		return []string{}, nil
+	}
+	if !strings.HasPrefix(trimValue, "[") {
+		return strings.Split(trimValue, ","), nil
+	}
+	var items []interface{}
+	if err := json.Unmarshal([]byte(trimValue), &items); err != nil {
+		return []string{}, err
+	}
+	array := make([]string, 0, len(items))
+	for _, item := range items {
+		if str, ok := item.(string); ok {
+			array = append(array, str)
+			continue
+		}
+		if item == nil || reflect.TypeOf(item).Kind() == reflect.Map || reflect.TypeOf(item).Kind() == reflect.Slice {
+			jsonStr, _ := json.Marshal(item)
+			array = append(array, string(jsonStr))
+			continue
+		}
+		array = append(array, fmt.Sprint(item))
+	}
+	return array, nil
+}
+
+// 加载映射表配置，按照映射名分类
+func loadEnums(path string) map[string]map[string]string {
+	enums := make(map[string]map[string]string)
+	if len(path) == 0 {
+		return enums
+	}
+	enumStorage := NewStorage(reflect.TypeOf(model.EnumSetting{}))
+	enumStorage.Load(path)
+	for _, item := range enumStorage.GetAll() {
+		setting := item.(*model.EnumSetting)
+		mapping := enums[setting.Enum]
+		if mapping == nil {
+			mapping = make(map[string]string)
+			enums[setting.Enum] = mapping
+		}
+		mapping[setting.Key] = setting.Value
+	}
+	return enums
+}
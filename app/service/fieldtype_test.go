@@ -0,0 +1,68 @@
+package service
+
+import (
+	"testing"
+	"time"
+	"xai.com/shushu/app/model"
+)
+
+func TestCompileFieldType(t *testing.T) {
+	enums := map[string]map[string]string{"ItemName": {"1001": "金币"}}
+	for _, dataType := range []string{"string", "[S", "[F", "json", "date_s", "date(2006-01-02 15:04:05|Asia/Shanghai)", "enum(ItemName)"} {
+		if err := compileFieldType(&model.Field{DataType: dataType}, enums); err != nil {
+			t.Fatal(dataType, err)
+		}
+	}
+	for _, dataType := range []string{"long", "date(|Asia/Shanghai)", "date(2006-01-02|Mars/Base)", "enum(Unknown)", "int(1)"} {
+		if err := compileFieldType(&model.Field{DataType: dataType}, enums); err == nil {
+			t.Fatal(dataType, "应该校验失败")
+		}
+	}
+}
+
+func TestParseDateLayout(t *testing.T) {
+	field := &model.Field{DataType: "date(2006-01-02 15:04:05|Asia/Shanghai)"}
+	if err := compileFieldType(field, nil); err != nil {
+		t.Fatal(err)
+	}
+	curTime, err := parseDate(field, "2021-05-02 08:00:00", time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if curTime.UTC() != time.Date(2021, 5, 2, 0, 0, 0, 0, time.UTC) {
+		t.Fatal("格式化日期时区解析错误", curTime.UTC())
+	}
+	seconds := &model.Field{DataType: "date_s"}
+	_ = compileFieldType(seconds, nil)
+	curTime, _ = parseDate(seconds, "1622626947", nil)
+	if curTime.Unix() != 1622626947 {
+		t.Fatal("秒时间戳解析错误", curTime)
+	}
+}
+
+func TestConvertValue(t *testing.T) {
+	field := &model.Field{DataType: "[S"}
+	_ = compileFieldType(field, nil)
+	value, err := convertValue(field, `["a",1]`)
+	if err != nil || len(value.([]string)) != 2 || value.([]string)[1] != "1" {
+		t.Fatal("字符串列表解析错误", value, err)
+	}
+	value, _ = convertValue(field, "a,b,c")
+	if len(value.([]string)) != 3 {
+		t.Fatal("逗号分隔字符串列表解析错误", value)
+	}
+	enum := &model.Field{DataType: "enum(ItemName)"}
+	_ = compileFieldType(enum, map[string]map[string]string{"ItemName": {"1001": "金币"}})
+	if value, _ = convertValue(enum, "1001"); value != "金币" {
+		t.Fatal("映射转换错误", value)
+	}
+	if value, err = convertValue(enum, "1002"); err == nil || value != "1002" {
+		t.Fatal("映射不存在时应该返回原值和错误", value)
+	}
+	jsonField := &model.Field{DataType: "json"}
+	_ = compileFieldType(jsonField, nil)
+	value, err = convertValue(jsonField, `{"a":1}`)
+	if err != nil || value.(map[string]interface{})["a"] != 1.0 {
+		t.Fatal("json解析错误", value, err)
+	}
+}
@@ -0,0 +1,72 @@
+package service
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"xai.com/shushu/app/model"
+)
+
+// 过滤规则指标分组，key为 事件标识#规则id
+const filterMetrics = "filtered_rows"
+
+// 账号黑名单 map[string]struct{}
+var accountBlacklist atomic.Value
+
+func init() {
+	accountBlacklist.Store(make(map[string]struct{}))
+	exprFunctions["blacklisted"] = &exprFunction{minArgs: 1, maxArgs: 1, call: func(_ *exprContext, _ *callNode, args []interface{}) (interface{}, error) {
+		_, ok := accountBlacklist.Load().(map[string]struct{})[toString(args[0])]
+		return ok, nil
+	}}
+}
+
+// 加载账号黑名单文件，每行一个账号，#开头为注释
+func LoadAccountBlacklist(path string) {
+	blacklist := make(map[string]struct{})
+	if len(path) == 0 {
+		accountBlacklist.Store(blacklist)
+		return
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer func() { _ = f.Close() }()
+	r := bufio.NewReader(f)
+	for {
+		b, _, err := r.ReadLine()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			panic(err)
+		}
+		s := strings.TrimSpace(string(b))
+		if len(s) == 0 || strings.HasPrefix(s, "#") {
+			continue
+		}
+		blacklist[s] = struct{}{}
+	}
+	accountBlacklist.Store(blacklist)
+	log.Println("加载账号黑名单", path, "数量", len(blacklist))
+}
+
+// 按照事件的过滤规则判断当前行是否需要上报，规则表达式为true时保留
+func acceptRow(eventConfig *model.EventConfig, row *logRow) bool {
+	for _, filter := range eventConfig.Filters {
+		accept, err := mustExpression(filter.Expression).EvalBool(&exprContext{row: row})
+		if err != nil {
+			log.Println(eventConfig.Name, "过滤规则", filter.Id, "计算失败,忽视当前行", err, ">>", row.String())
+		}
+		if err != nil || !accept {
+			addMetric(filterMetrics, eventConfig.Identity()+"#"+strconv.Itoa(filter.Id), 1)
+			return false
+		}
+	}
+	return true
+}
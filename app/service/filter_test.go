@@ -0,0 +1,44 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"xai.com/shushu/app/model"
+)
+
+func TestAcceptRow(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blacklist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	path := filepath.Join(dir, "blacklist")
+	if err = ioutil.WriteFile(path, []byte("# GM账号\ngm_1\n\ntest_2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	LoadAccountBlacklist(path)
+	defer LoadAccountBlacklist("")
+
+	eventConfig := &model.EventConfig{Name: "item_record", RecordName: "ItemRecord", UploadType: model.UploadTrack, Filters: []*model.Filter{
+		{Id: 1, Expression: `!blacklisted($5)`},
+		{Id: 2, Expression: `in($13, "shop", "mall")`},
+	}}
+	decoder, _ := NewLineDecoder("delim(,)")
+	cases := map[string]bool{
+		"1,1,1,1,acc_1,1,1,1,1,1,1,1,shop": true,
+		"1,1,1,1,gm_1,1,1,1,1,1,1,1,shop":  false,
+		"1,1,1,1,acc_1,1,1,1,1,1,1,1,gm":   false,
+	}
+	first, second := getMetric(filterMetrics, eventConfig.Identity()+"#1"), getMetric(filterMetrics, eventConfig.Identity()+"#2")
+	for line, expect := range cases {
+		row, _ := decoder.Decode(line)
+		if acceptRow(eventConfig, row) != expect {
+			t.Fatal(line, "过滤结果错误")
+		}
+	}
+	if getMetric(filterMetrics, eventConfig.Identity()+"#1")-first != 1 || getMetric(filterMetrics, eventConfig.Identity()+"#2")-second != 1 {
+		t.Fatal("过滤计数错误")
+	}
+}
@@ -0,0 +1,191 @@
+package service
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"xai.com/shushu/app/model"
+)
+
+// 默认的记录分割方式，\r和\n都作为结束符
+const defaultFraming = "line"
+
+// 长度前缀记录的最大长度，超过时认为文件损坏
+const maxFrameLength = 64 * 1024 * 1024
+
+// 从文件内容中切分记录
+type recordFramer interface {
+	// 从data开始切分一条记录，记录内容为data[start:end]，start==end时为空记录直接跳过。
+	// 没有完整记录时返回advance=0；final为true时data是文件剩余的全部内容，不会再有新数据
+	split(data []byte, final bool) (advance, start, end int, err error)
+}
+
+var framerCache sync.Map
+
+// 根据配置获取记录分割方式，支持 line, lf, crlf, continuation(正则), length, length(2)
+func newRecordFramer(spec string) (recordFramer, error) {
+	spec = strings.TrimSpace(spec)
+	if len(spec) == 0 {
+		spec = defaultFraming
+	}
+	if framer, ok := framerCache.Load(spec); ok {
+		return framer.(recordFramer), nil
+	}
+	name, args := spec, ""
+	if start := strings.Index(spec, "("); start > 0 && strings.HasSuffix(spec, ")") {
+		name, args = spec[:start], spec[start+1:len(spec)-1]
+	}
+	var framer recordFramer
+	switch name {
+	case "line":
+		framer = &lineFramer{}
+	case "lf":
+		framer = &delimiterFramer{delimiter: []byte("\n")}
+	case "crlf":
+		framer = &delimiterFramer{delimiter: []byte("\r\n")}
+	case "continuation":
+		if len(args) == 0 {
+			return nil, errors.New("continuation必须配置续行正则,如continuation(^\\s)")
+		}
+		pattern, err := regexp.Compile(args)
+		if err != nil {
+			return nil, fmt.Errorf("续行正则错误[%s]:%s", args, err.Error())
+		}
+		framer = &continuationFramer{pattern: pattern}
+	case "length":
+		width := 4
+		if len(args) > 0 {
+			var err error
+			width, err = strconv.Atoi(args)
+			if err != nil || (width != 2 && width != 4) {
+				return nil, fmt.Errorf("长度前缀只能是2或者4字节[%s]", args)
+			}
+		}
+		framer = &lengthFramer{width: width}
+	default:
+		return nil, fmt.Errorf("不支持的记录分割方式[%s]", spec)
+	}
+	actual, _ := framerCache.LoadOrStore(spec, framer)
+	return actual.(recordFramer), nil
+}
+
+// 日志文件的记录分割方式，日志名配置优先于日志类型配置，格式 tlog=lf;ChatRecord=continuation(^\s)
+func framerFor(config *model.AppConfig, recordName, logType string) recordFramer {
+	framings := parseLineDecoders(config.RecordFraming)
+	spec, ok := framings[recordName]
+	if !ok {
+		spec = framings[logType]
+	}
+	framer, err := newRecordFramer(spec)
+	if err != nil {
+		panic("RecordFraming配置错误" + recordName + ":" + err.Error())
+	}
+	return framer
+}
+
+// \r和\n都作为结束符，兼容之前的读取方式
+type lineFramer struct {
+}
+
+func (f *lineFramer) split(data []byte, final bool) (int, int, int, error) {
+	if index := bytes.IndexAny(data, "\r\n"); index >= 0 {
+		return index + 1, 0, index, nil
+	}
+	if final && len(data) > 0 {
+		return len(data), 0, len(data), nil
+	}
+	return 0, 0, 0, nil
+}
+
+// 固定结束符，记录中可以包含其他换行符
+type delimiterFramer struct {
+	delimiter []byte
+}
+
+func (f *delimiterFramer) split(data []byte, final bool) (int, int, int, error) {
+	if index := bytes.Index(data, f.delimiter); index >= 0 {
+		return index + len(f.delimiter), 0, index, nil
+	}
+	if final && len(data) > 0 {
+		return len(data), 0, len(data), nil
+	}
+	return 0, 0, 0, nil
+}
+
+// 按照\n分行，匹配续行正则的行属于上一条记录(如堆栈信息)。
+// 需要读取到下一行才能确定记录结束，文件末尾的记录在final时才输出
+type continuationFramer struct {
+	pattern *regexp.Regexp
+}
+
+func (f *continuationFramer) split(data []byte, final bool) (int, int, int, error) {
+	index := bytes.IndexByte(data, '\n')
+	if index < 0 {
+		if final && len(data) > 0 {
+			return len(data), 0, trimCR(data, len(data)), nil
+		}
+		return 0, 0, 0, nil
+	}
+	end := index
+	for {
+		next := data[end+1:]
+		nextIndex := bytes.IndexByte(next, '\n')
+		if nextIndex < 0 && !final {
+			// 下一行没有结束，无法判断是否为续行
+			return 0, 0, 0, nil
+		}
+		if nextIndex < 0 {
+			nextIndex = len(next)
+		}
+		if len(next) == 0 || !f.pattern.Match(next[:trimCR(next, nextIndex)]) {
+			return end + 1, 0, trimCR(data, end), nil
+		}
+		end += 1 + nextIndex
+		if end >= len(data) {
+			return len(data), 0, trimCR(data, len(data)), nil
+		}
+	}
+}
+
+// 去掉行尾的\r
+func trimCR(data []byte, end int) int {
+	if end > 0 && data[end-1] == '\r' {
+		return end - 1
+	}
+	return end
+}
+
+// 长度前缀记录，大端整数长度后跟记录内容
+type lengthFramer struct {
+	width int
+}
+
+func (f *lengthFramer) split(data []byte, final bool) (int, int, int, error) {
+	if len(data) < f.width {
+		if final && len(data) > 0 {
+			return 0, 0, 0, fmt.Errorf("长度前缀不完整,剩余%d字节", len(data))
+		}
+		return 0, 0, 0, nil
+	}
+	var length int
+	if f.width == 2 {
+		length = int(binary.BigEndian.Uint16(data))
+	} else {
+		length = int(binary.BigEndian.Uint32(data))
+	}
+	if length > maxFrameLength {
+		return 0, 0, 0, fmt.Errorf("记录长度%d超过限制", length)
+	}
+	if len(data) < f.width+length {
+		if final {
+			return 0, 0, 0, fmt.Errorf("记录不完整,长度%d,剩余%d字节", length, len(data)-f.width)
+		}
+		return 0, 0, 0, nil
+	}
+	return f.width + length, f.width, f.width + length, nil
+}
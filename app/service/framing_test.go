@@ -0,0 +1,119 @@
+package service
+
+import (
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"xai.com/shushu/app/model"
+)
+
+// 扫描文件内容，返回所有记录，检查记录位置
+func scanRecords(t *testing.T, spec string, content []byte, final bool) ([]string, int64) {
+	framer, err := newRecordFramer(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "framing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	path := filepath.Join(dir, "1_1_ChatRecord.2021-05-02")
+	if err := ioutil.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	records := make([]string, 0)
+	var last int64
+	err = scanFile(context.Background(), path, 0, &fileFormat{framer: framer}, final, func(position int64, lines []string, offsets []int64) error {
+		for i, line := range lines {
+			if string(content[offsets[i]:offsets[i]+int64(len(line))]) != line {
+				t.Fatal("记录位置错误", offsets[i], line)
+			}
+		}
+		records = append(records, lines...)
+		last = position
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return records, last
+}
+
+func TestFraming(t *testing.T) {
+	content := "a\tb\r\nchat\tline1\nline2\r\n\r\nc"
+	cases := []struct {
+		spec    string
+		records []string
+	}{
+		{"", []string{"a\tb", "chat\tline1", "line2", "c"}},
+		{"lf", []string{"a\tb\r", "chat\tline1", "line2\r", "\r", "c"}},
+		{"crlf", []string{"a\tb", "chat\tline1\nline2", "c"}},
+		{`continuation(^line)`, []string{"a\tb", "chat\tline1\nline2", "c"}},
+	}
+	for _, c := range cases {
+		records, position := scanRecords(t, c.spec, []byte(content), true)
+		if !reflect.DeepEqual(records, c.records) || position != int64(len(content)) {
+			t.Fatal(c.spec, "记录切分错误", records, position)
+		}
+	}
+}
+
+func TestFramingBoundary(t *testing.T) {
+	// 没有结束的记录留到下一次扫描，断点停在记录开始位置
+	records, position := scanRecords(t, "lf", []byte("a\nb\nc"), false)
+	if !reflect.DeepEqual(records, []string{"a", "b"}) || position != 4 {
+		t.Fatal("断点应该停在记录边界", records, position)
+	}
+	// 续行需要读取到下一行才能确定记录结束
+	records, position = scanRecords(t, `continuation(^\s)`, []byte("a\n at x\n at y\nb\n"), false)
+	if !reflect.DeepEqual(records, []string{"a\n at x\n at y"}) || position != 14 {
+		t.Fatal("续行记录错误", records, position)
+	}
+	// 跨越多个读取批次的记录
+	long := "x" + strings.Repeat("\tstack", 50000)
+	records, _ = scanRecords(t, `continuation(^\t)`, []byte("a\n"+strings.Replace(long, "\t", "\n\t", -1)+"\nb\n"), true)
+	if len(records) != 3 || len(records[1]) != len(long)+50000 {
+		t.Fatal("跨批次记录错误", len(records))
+	}
+}
+
+func TestLengthFraming(t *testing.T) {
+	content := make([]byte, 0)
+	for _, record := range []string{"a\nb", "", "chat"} {
+		header := make([]byte, 4)
+		binary.BigEndian.PutUint32(header, uint32(len(record)))
+		content = append(append(content, header...), record...)
+	}
+	records, position := scanRecords(t, "length", append(content, 0, 0), false)
+	if !reflect.DeepEqual(records, []string{"a\nb", "chat"}) || position != int64(len(content)) {
+		t.Fatal("长度前缀切分错误", records, position)
+	}
+	framer, _ := newRecordFramer("length(2)")
+	if _, _, _, err := framer.split([]byte{0, 5, 'a'}, true); err == nil {
+		t.Fatal("文件结束时记录不完整应该返回错误")
+	}
+}
+
+func TestFramerConfig(t *testing.T) {
+	for _, spec := range []string{"continuation", "continuation([)", "length(3)", "unknown"} {
+		if _, err := newRecordFramer(spec); err == nil {
+			t.Fatal("配置错误没有返回错误", spec)
+		}
+	}
+	config := &model.AppConfig{RecordFraming: "tlog=crlf;ChatRecord=continuation(^\\s)"}
+	if _, ok := framerFor(config, "ChatRecord", "tlog").(*continuationFramer); !ok {
+		t.Fatal("日志名配置应该优先")
+	}
+	if _, ok := framerFor(config, "ItemRecord", "tlog").(*delimiterFramer); !ok {
+		t.Fatal("应该使用日志类型配置")
+	}
+	if _, ok := framerFor(config, "ItemRecord", "flog").(*lineFramer); !ok {
+		t.Fatal("没有配置时使用默认分割方式")
+	}
+}
@@ -0,0 +1,155 @@
+package service
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"xai.com/shushu/app/model"
+)
+
+// 默认上报超时时间
+const defaultHttpTimeout = 3 * time.Second
+
+// 所有上报请求附加的请求头
+var httpHeaders map[string]string
+
+// 根据配置创建上报使用的http客户端，支持自定义CA，客户端证书(mTLS)，代理和超时时间
+func newHttpClient(config *model.AppConfig) (*http.Client, error) {
+	timeout := defaultHttpTimeout
+	if len(config.HttpTimeout) > 0 {
+		duration, err := time.ParseDuration(config.HttpTimeout)
+		if err != nil || duration <= 0 {
+			return nil, fmt.Errorf("HttpTimeout配置错误[%s]", config.HttpTimeout)
+		}
+		timeout = duration
+	}
+	tlsConfig, err := newTlsConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	transport := &http.Transport{
+		MaxIdleConnsPerHost: 10,
+		MaxConnsPerHost:     10,
+		IdleConnTimeout:     time.Minute,
+		TLSClientConfig:     tlsConfig,
+	}
+	switch proxy := strings.TrimSpace(config.HttpProxy); proxy {
+	case "":
+	case "env":
+		transport.Proxy = http.ProxyFromEnvironment
+	default:
+		proxyUrl, err := url.Parse(proxy)
+		if err != nil || len(proxyUrl.Host) == 0 {
+			return nil, fmt.Errorf("HttpProxy配置错误[%s]", proxy)
+		}
+		transport.Proxy = http.ProxyURL(proxyUrl)
+	}
+	return &http.Client{Transport: transport, Timeout: timeout}, nil
+}
+
+func newTlsConfig(config *model.AppConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+	if len(config.HttpCaFile) > 0 {
+		content, err := ioutil.ReadFile(config.HttpCaFile)
+		if err != nil {
+			return nil, fmt.Errorf("读取CA证书失败[%s]:%s", config.HttpCaFile, err.Error())
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(content) {
+			return nil, fmt.Errorf("CA证书格式错误[%s]", config.HttpCaFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if len(config.HttpCertFile) > 0 || len(config.HttpKeyFile) > 0 {
+		if len(config.HttpCertFile) == 0 || len(config.HttpKeyFile) == 0 {
+			return nil, errors.New("客户端证书HttpCertFile和HttpKeyFile必须同时配置")
+		}
+		cert, err := tls.LoadX509KeyPair(config.HttpCertFile, config.HttpKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("加载客户端证书失败:%s", err.Error())
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if len(config.HttpInsecureSkipVerify) > 0 {
+		skip, err := strconv.ParseBool(config.HttpInsecureSkipVerify)
+		if err != nil {
+			return nil, fmt.Errorf("HttpInsecureSkipVerify配置错误[%s]", config.HttpInsecureSkipVerify)
+		}
+		tlsConfig.InsecureSkipVerify = skip
+	}
+	return tlsConfig, nil
+}
+
+// 解析附加请求头，格式 X-Env=prod;X-Token=abc
+func parseHttpHeaders(config string) (map[string]string, error) {
+	headers := make(map[string]string)
+	for _, item := range strings.Split(config, ";") {
+		item = strings.TrimSpace(item)
+		if len(item) == 0 {
+			continue
+		}
+		index := strings.Index(item, "=")
+		if index <= 0 {
+			return nil, fmt.Errorf("HttpHeaders配置错误[%s],格式为名称=值", item)
+		}
+		headers[http.CanonicalHeaderKey(strings.TrimSpace(item[:index]))] = strings.TrimSpace(item[index+1:])
+	}
+	return headers, nil
+}
+
+// 检查上报地址是否可以连通，收到任意http响应即认为证书，代理配置正确
+func checkConnectivity(ctx context.Context, target *destination) error {
+	request, err := http.NewRequestWithContext(ctx, "GET", target.url, nil)
+	if err != nil {
+		return err
+	}
+	for k, v := range httpHeaders {
+		request.Header.Set(k, v)
+	}
+	res, err := httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(ioutil.Discard, res.Body)
+	_ = res.Body.Close()
+	log.Println(target, "连通性检查成功", target.url, res.Status)
+	return nil
+}
+
+// 检查所有输出目标的上报地址，任意一个无法连通时返回错误
+func checkAllDestinations(ctx context.Context) error {
+	checked := make(map[string]bool)
+	for _, sinkName := range SinkNames() {
+		router := sinks[sinkName].router
+		if router == nil {
+			continue
+		}
+		targets := make([]*destination, 0, len(router.routes)+1)
+		if router.defaultDestination != nil {
+			targets = append(targets, router.defaultDestination)
+		}
+		for _, route := range router.routes {
+			targets = append(targets, route.destination)
+		}
+		for _, target := range targets {
+			if checked[target.url] {
+				continue
+			}
+			checked[target.url] = true
+			if err := checkConnectivity(ctx, target); err != nil {
+				return fmt.Errorf("%s连通性检查失败[%s]:%s", target, target.url, err.Error())
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package service
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"xai.com/shushu/app/model"
+)
+
+// 生成证书，parent为空时生成自签名CA
+func newTestCert(t *testing.T, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, []byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+	return cert, key, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+}
+
+func writeTestFile(t *testing.T, dir, name string, content []byte) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestMutualTls(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	ca, caKey, caPem, _ := newTestCert(t, "test ca", nil, nil)
+	_, _, serverPem, serverKeyPem := newTestCert(t, "server", ca, caKey)
+	_, _, clientPem, clientKeyPem := newTestCert(t, "client", ca, caKey)
+	serverCert, err := tls.X509KeyPair(serverPem, serverKeyPem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Env") != "prod" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		_, _ = w.Write([]byte(`{"code":0}`))
+	}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{serverCert}, ClientCAs: pool, ClientAuth: tls.RequireAndVerifyClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	config := &model.AppConfig{
+		HttpCaFile:   writeTestFile(t, dir, "ca.pem", caPem),
+		HttpCertFile: writeTestFile(t, dir, "client.pem", clientPem),
+		HttpKeyFile:  writeTestFile(t, dir, "client.key", clientKeyPem),
+		HttpTimeout:  "2s",
+	}
+	httpHeaders, _ = parseHttpHeaders("x-env=prod")
+	defer func() { httpHeaders = nil }()
+	httpClient, err = newHttpClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := &destination{name: "mtls", url: server.URL, appId: "app", retryTimes: 1}
+	if err := checkConnectivity(context.Background(), target); err != nil {
+		t.Fatal("mTLS连通性检查失败", err)
+	}
+	if err := uploadRows(context.Background(), target, []*uploadRow{testUploadRow(map[string]interface{}{"#type": "track"}, nil)}); err != nil {
+		t.Fatal("mTLS上报失败", err)
+	}
+
+	// 没有客户端证书时握手失败
+	config.HttpCertFile, config.HttpKeyFile = "", ""
+	httpClient, _ = newHttpClient(config)
+	if err := checkConnectivity(context.Background(), target); err == nil {
+		t.Fatal("没有客户端证书应该连接失败")
+	}
+}
+
+func TestHttpProxy(t *testing.T) {
+	proxied := false
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.Host == "collector.test"
+		_, _ = w.Write([]byte(`{"code":0}`))
+	}))
+	defer proxy.Close()
+	client, err := newHttpClient(&model.AppConfig{HttpProxy: proxy.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpClient = client
+	if err := checkConnectivity(context.Background(), &destination{name: "proxy", url: "http://collector.test/sync_server"}); err != nil || !proxied {
+		t.Fatal("没有通过代理访问", err)
+	}
+}
+
+func TestHttpClientConfigError(t *testing.T) {
+	for _, config := range []*model.AppConfig{
+		{HttpTimeout: "abc"},
+		{HttpProxy: "::"},
+		{HttpCaFile: "not_exist.pem"},
+		{HttpCertFile: "client.pem"},
+		{HttpInsecureSkipVerify: "maybe"},
+	} {
+		if _, err := newHttpClient(config); err == nil {
+			t.Fatal("应该校验失败", config)
+		}
+	}
+	if _, err := parseHttpHeaders("X-Env"); err == nil {
+		t.Fatal("请求头格式错误应该校验失败")
+	}
+}
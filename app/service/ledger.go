@@ -0,0 +1,306 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 上报时间格式
+const ledgerTimeLayout = "2006-01-02 15:04:05"
+
+// 每个日志文件的上报记录
+type LedgerEntry struct {
+	Id          string           `json:"id"`
+	PositionId  string           `json:"position_id"`
+	Operator    int              `json:"operator"`
+	Server      int              `json:"server"`
+	Log         string           `json:"log"`
+	Sink        string           `json:"sink"`
+	Day         string           `json:"day"`
+	Path        string           `json:"path"`
+	Size        int64            `json:"size"`     // 标记完成时的文件大小
+	Position    int64            `json:"position"` // 已经处理的字节数
+	Lines       int64            `json:"lines"`    // 读取的行数
+	Sent        map[string]int64 `json:"sent"`     // 每个事件上报的行数
+	Acked       int64            `json:"acked"`    // 至少被一个事件上报的行数
+	Filtered    int64            `json:"filtered"` // 被过滤规则或者路由忽视的行数
+	Dropped     int64            `json:"dropped"`  // 解码失败或者校验失败没有上报的行数
+	FirstUpload string           `json:"first_upload"`
+	LastUpload  string           `json:"last_upload"`
+	Completed   bool             `json:"completed"`
+	Reconcile   string           `json:"reconcile"` // 对账结果,ok或者不一致的原因,为空表示没有对账
+}
+
+func newLedgerEntry(position *logPosition, day, path string) *LedgerEntry {
+	return &LedgerEntry{
+		Id:         ledgerId(position.Id, day),
+		PositionId: position.Id,
+		Operator:   position.Operator,
+		Server:     position.Server,
+		Log:        position.Log,
+		Sink:       position.Sink,
+		Day:        day,
+		Path:       path,
+		Sent:       make(map[string]int64),
+	}
+}
+
+func ledgerId(positionId, day string) string {
+	return positionId + "#" + day
+}
+
+// 复制一份用于延迟写入
+func (e *LedgerEntry) clone() LedgerEntry {
+	copied := *e
+	copied.Sent = make(map[string]int64, len(e.Sent))
+	for k, v := range e.Sent {
+		copied.Sent[k] = v
+	}
+	return copied
+}
+
+// 记录一批处理结果
+func (e *LedgerEntry) record(position int64, lines int, stats *BatchStats, now time.Time) {
+	e.Position = position
+	e.Lines += int64(lines)
+	if stats == nil {
+		return
+	}
+	e.Acked += stats.Acked
+	e.Filtered += stats.Filtered
+	e.Dropped += stats.Dropped
+	var sent int64
+	for event, rows := range stats.Sent {
+		e.Sent[event] += rows
+		sent += rows
+	}
+	if sent > 0 {
+		e.LastUpload = now.Format(ledgerTimeLayout)
+		if len(e.FirstUpload) == 0 {
+			e.FirstUpload = e.LastUpload
+		}
+	}
+}
+
+// 标记文件上报完成，记录最终文件大小
+func (e *LedgerEntry) complete() {
+	if info, err := os.Stat(e.Path); err == nil {
+		e.Size = info.Size()
+	}
+	e.Completed = true
+}
+
+// 对账，文件行数和读取行数一致，并且每一行都有处理结果
+func (e *LedgerEntry) reconcile(fileLines int64) {
+	switch {
+	case fileLines != e.Lines:
+		e.Reconcile = fmt.Sprintf("文件行数%d,读取行数%d", fileLines, e.Lines)
+	case e.Acked+e.Filtered+e.Dropped != e.Lines:
+		e.Reconcile = fmt.Sprintf("读取行数%d,上报%d,过滤%d,忽视%d", e.Lines, e.Acked, e.Filtered, e.Dropped)
+	default:
+		e.Reconcile = "ok"
+	}
+}
+
+// 一批日志的处理结果，由消费者填写，每一行只能计入上报，过滤，忽视中的一个
+type BatchStats struct {
+	// 每个事件上报的行数
+	Sent map[string]int64
+
+	// 至少被一个事件上报的行数
+	Acked int64
+
+	// 被过滤规则或者路由忽视的行数
+	Filtered int64
+
+	// 解码失败或者校验失败没有上报的行数
+	Dropped int64
+}
+
+func newBatchStats() *BatchStats {
+	return &BatchStats{Sent: make(map[string]int64)}
+}
+
+func (s *BatchStats) sent(event string, rows int) {
+	if s != nil {
+		s.Sent[event] += int64(rows)
+	}
+}
+
+func (s *BatchStats) acked(lines int) {
+	if s != nil {
+		s.Acked += int64(lines)
+	}
+}
+
+func (s *BatchStats) filtered(lines int) {
+	if s != nil {
+		s.Filtered += int64(lines)
+	}
+}
+
+func (s *BatchStats) dropped(lines int) {
+	if s != nil {
+		s.Dropped += int64(lines)
+	}
+}
+
+const ledgerColumns = "id,position_id,operator,server,log,sink,file_day,path,size,position,line_count,rows_sent,rows_dropped,first_upload,last_upload,completed,acked_lines,filtered_lines,reconcile"
+
+// 上报记录存在时更新的列
+const ledgerUpdateColumns = "path,size,position,line_count,rows_sent,rows_dropped,first_upload,last_upload,completed,acked_lines,filtered_lines,reconcile"
+
+func upsertLedger(ctx context.Context, stmt *sql.Stmt, entry *LedgerEntry) error {
+	sent, _ := json.Marshal(entry.Sent)
+	completed := 0
+	if entry.Completed {
+		completed = 1
+	}
+	_, err := stmt.ExecContext(ctx, entry.Id, entry.PositionId, entry.Operator, entry.Server, entry.Log, entry.Sink, entry.Day, entry.Path,
+		entry.Size, entry.Position, entry.Lines, string(sent), entry.Dropped, entry.FirstUpload, entry.LastUpload, completed, entry.Acked, entry.Filtered, entry.Reconcile)
+	if err != nil {
+		return fmt.Errorf("%s保存上报记录失败%s", entry.Id, err.Error())
+	}
+	return nil
+}
+
+// 上报记录查询条件，为空或者0时不过滤
+type LedgerFilter struct {
+	Operator  int
+	Server    int
+	Log       string
+	Sink      string
+	Day       string
+	Completed string // true,false
+	Mismatch  bool   // 只查询对账不一致的记录
+	Limit     int
+}
+
+// 查询上报记录，按照日期倒序
+func QueryLedger(ctx context.Context, filter LedgerFilter) ([]*LedgerEntry, error) {
+	if dbPool == nil {
+		panic("数据库未完成初始化")
+	}
+	conditions := make([]string, 0, 5)
+	args := make([]interface{}, 0, 5)
+	if filter.Operator > 0 {
+		conditions = append(conditions, "operator=?")
+		args = append(args, filter.Operator)
+	}
+	if filter.Server > 0 {
+		conditions = append(conditions, "server=?")
+		args = append(args, filter.Server)
+	}
+	if len(filter.Log) > 0 {
+		conditions = append(conditions, "log=?")
+		args = append(args, filter.Log)
+	}
+	if len(filter.Sink) > 0 {
+		conditions = append(conditions, "sink=?")
+		args = append(args, filter.Sink)
+	}
+	if len(filter.Day) > 0 {
+		conditions = append(conditions, "file_day=?")
+		args = append(args, filter.Day)
+	}
+	if len(filter.Completed) > 0 {
+		completed, err := strconv.ParseBool(filter.Completed)
+		if err != nil {
+			return nil, fmt.Errorf("completed参数错误[%s]", filter.Completed)
+		}
+		conditions = append(conditions, "completed=?")
+		if completed {
+			args = append(args, 1)
+		} else {
+			args = append(args, 0)
+		}
+	}
+	if filter.Mismatch {
+		conditions = append(conditions, "reconcile<>'' and reconcile<>'ok'")
+	}
+	query := "select " + ledgerColumns + " from file_ledger"
+	if len(conditions) > 0 {
+		query += " where " + strings.Join(conditions, " and ")
+	}
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+	query += " order by file_day desc,id limit " + strconv.Itoa(limit)
+	rows, err := dbPool.QueryContext(ctx, dbDialect.rebind(query), args...)
+	if err != nil {
+		return nil, fmt.Errorf("查询上报记录失败%s", err.Error())
+	}
+	defer func() { _ = rows.Close() }()
+	result := make([]*LedgerEntry, 0)
+	for rows.Next() {
+		entry, err := scanLedger(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, entry)
+	}
+	return result, rows.Err()
+}
+
+// 加载一个文件的上报记录，不存在时返回nil
+func loadLedger(ctx context.Context, id string) (*LedgerEntry, error) {
+	rows, err := dbPool.QueryContext(ctx, dbDialect.rebind("select "+ledgerColumns+" from file_ledger where id=?"), id)
+	if err != nil {
+		return nil, fmt.Errorf("查询上报记录失败%s", err.Error())
+	}
+	defer func() { _ = rows.Close() }()
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+	return scanLedger(rows)
+}
+
+func scanLedger(rows *sql.Rows) (*LedgerEntry, error) {
+	entry := &LedgerEntry{}
+	var sent string
+	var completed int
+	err := rows.Scan(&entry.Id, &entry.PositionId, &entry.Operator, &entry.Server, &entry.Log, &entry.Sink, &entry.Day, &entry.Path,
+		&entry.Size, &entry.Position, &entry.Lines, &sent, &entry.Dropped, &entry.FirstUpload, &entry.LastUpload, &completed, &entry.Acked, &entry.Filtered, &entry.Reconcile)
+	if err != nil {
+		return nil, fmt.Errorf("读取上报记录失败%s", err.Error())
+	}
+	entry.Sent = make(map[string]int64)
+	if len(sent) > 0 {
+		if err := json.Unmarshal([]byte(sent), &entry.Sent); err != nil {
+			return nil, fmt.Errorf("%s上报行数格式错误%s", entry.Id, err.Error())
+		}
+	}
+	entry.Completed = completed != 0
+	return entry, nil
+}
+
+// 管理接口 /admin/ledger?operator=3&server=12&log=ItemRecord&day=2021-05-02&completed=false&mismatch=true
+func LedgerHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := LedgerFilter{Log: query.Get("log"), Sink: query.Get("sink"), Day: query.Get("day"), Completed: query.Get("completed")}
+	filter.Mismatch, _ = strconv.ParseBool(query.Get("mismatch"))
+	var err error
+	for name, value := range map[string]*int{"operator": &filter.Operator, "server": &filter.Server, "limit": &filter.Limit} {
+		if str := query.Get(name); len(str) > 0 {
+			if *value, err = strconv.Atoi(str); err != nil {
+				http.Error(w, name+"参数错误", http.StatusBadRequest)
+				return
+			}
+		}
+	}
+	entries, err := QueryLedger(r.Context(), filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(entries)
+}
@@ -0,0 +1,149 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// 在临时目录中创建日志文件，返回根目录
+func writeTestLogs(t *testing.T, files map[string]string) string {
+	root, err := ioutil.TempDir("", "logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		path := filepath.Join(root, "8001", "logs", "tlog", name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func TestFileLedger(t *testing.T) {
+	defer useSqliteDatabase(t)()
+	today := dayIn(time.Now(), time.UTC)
+	yesterday := today.Add(-24 * time.Hour)
+	root := writeTestLogs(t, map[string]string{
+		"3_12_ItemRecord." + yesterday.Format("2006-01-02"): "a\nb\nbad\n",
+		"3_12_ItemRecord." + today.Format("2006-01-02"):     "c\n",
+	})
+	defer func() { _ = os.RemoveAll(root) }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	position := &logPosition{Id: positionId(3, 12, "ItemRecord", ""), Operator: 3, Server: 12, Log: "ItemRecord", LogType: "tlog", LastExecute: yesterday}
+	task := &logTask{logPosition: position, rootPath: root, relatePath: "logs", port: "8001", location: time.UTC, ctx: ctx, cancel: cancel, reconcile: true, format: &fileFormat{framer: &lineFramer{}}}
+	scanOneTask(context.Background(), task, testLedgerProcess)
+
+	entries, err := QueryLedger(context.Background(), LedgerFilter{Operator: 3, Server: 12, Log: "ItemRecord"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatal("每个文件应该有一条上报记录", len(entries))
+	}
+	done, current := entries[1], entries[0]
+	if done.Day != yesterday.Format("2006-01-02") || !done.Completed || done.Lines != 3 || done.Sent["item_record"] != 2 || done.Acked != 2 || done.Dropped != 1 || done.Size != 8 || done.Reconcile != "ok" {
+		t.Fatal("前一天的文件应该标记上报完成", fmt.Sprintf("%+v", done))
+	}
+	if len(done.FirstUpload) == 0 || len(done.LastUpload) == 0 {
+		t.Fatal("缺少上报时间", done)
+	}
+	if current.Completed || current.Lines != 1 {
+		t.Fatal("当天的文件不应该标记完成", fmt.Sprintf("%+v", current))
+	}
+
+	recorder := httptest.NewRecorder()
+	LedgerHandler(recorder, httptest.NewRequest("GET", "/admin/ledger?server=12&completed=true", nil))
+	var result []*LedgerEntry
+	if err := json.Unmarshal(recorder.Body.Bytes(), &result); err != nil || len(result) != 1 || result[0].Id != done.Id {
+		t.Fatal("管理接口查询错误", recorder.Body.String())
+	}
+	recorder = httptest.NewRecorder()
+	LedgerHandler(recorder, httptest.NewRequest("GET", "/admin/ledger?server=abc", nil))
+	if recorder.Code != 400 {
+		t.Fatal("参数错误应该返回400", recorder.Code)
+	}
+}
+
+func testLedgerProcess(ctx context.Context, source *LogSource, lines []string) error {
+	for _, line := range lines {
+		if line == "bad" {
+			source.Stats.dropped(1)
+			continue
+		}
+		source.Stats.sent("item_record", 1)
+		source.Stats.acked(1)
+	}
+	return nil
+}
+
+func TestCompletionGrace(t *testing.T) {
+	defer useSqliteDatabase(t)()
+	today := dayIn(time.Now(), time.UTC)
+	yesterday := today.Add(-24 * time.Hour)
+	root := writeTestLogs(t, map[string]string{
+		"3_12_ItemRecord." + yesterday.Format("2006-01-02"): "a\nb\n",
+		"3_12_ItemRecord." + today.Format("2006-01-02"):     "c\n",
+	})
+	defer func() { _ = os.RemoveAll(root) }()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// 从文件中间开始读取，对账不一致
+	position := &logPosition{Id: positionId(3, 12, "ItemRecord", ""), Operator: 3, Server: 12, Log: "ItemRecord", LogType: "tlog", LastExecute: yesterday, Position: 2}
+	task := &logTask{logPosition: position, rootPath: root, relatePath: "logs", port: "8001", location: time.UTC, ctx: ctx, cancel: cancel, reconcile: true, format: &fileFormat{framer: &lineFramer{}}, grace: time.Hour}
+
+	yesterdayId := ledgerId(position.Id, yesterday.Format("2006-01-02"))
+	scanOneTask(context.Background(), task, testLedgerProcess)
+	if !position.LastExecute.Equal(today) || position.Position != 2 {
+		t.Fatal("宽限期内应该开始扫描下一天", position)
+	}
+	if task.closing == nil || task.closing.position != 4 {
+		t.Fatal("之前日期的文件应该等待写入结束", task.closing)
+	}
+	if entry, _ := loadLedger(context.Background(), yesterdayId); entry == nil || entry.Completed || entry.Position != 4 {
+		t.Fatal("宽限期内不应该标记完成", entry)
+	}
+
+	// 宽限期内写入的内容继续上报到之前日期的文件
+	yesterdayPath := task.closing.path
+	file, err := os.OpenFile(yesterdayPath, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = file.WriteString("d\n")
+	_ = file.Close()
+	scanOneTask(context.Background(), task, testLedgerProcess)
+	if task.closing == nil || task.closing.position != 6 || position.Position != 2 {
+		t.Fatal("应该读取之前日期的文件新写入的内容", task.closing, position)
+	}
+	if entry, _ := loadLedger(context.Background(), yesterdayId); entry == nil || entry.Lines != 2 || entry.Position != 6 {
+		t.Fatal("新写入的内容应该记录到之前日期的上报记录", entry)
+	}
+
+	// 重启后从上报记录恢复等待写入结束的文件
+	restarted := *position
+	task = &logTask{logPosition: &restarted, rootPath: root, relatePath: "logs", port: "8001", location: time.UTC, ctx: ctx, cancel: cancel, reconcile: true, format: &fileFormat{framer: &lineFramer{}}}
+	scanOneTask(context.Background(), task, testLedgerProcess)
+	if task.closing != nil || restarted.Position != 2 {
+		t.Fatal("超过宽限期后之前日期的文件应该结束", task.closing, restarted)
+	}
+	entry, _ := loadLedger(context.Background(), yesterdayId)
+	if entry == nil || !entry.Completed || entry.Lines != 2 || entry.Reconcile == "ok" {
+		t.Fatal("漏读的文件对账应该不一致", entry)
+	}
+	if entries, _ := QueryLedger(context.Background(), LedgerFilter{Mismatch: true}); len(entries) != 1 {
+		t.Fatal("应该可以查询对账不一致的记录", len(entries))
+	}
+}
@@ -1,65 +1,160 @@
 package service
 
 import (
-	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 	"xai.com/shushu/app/model"
 )
 
 var tasks sync.Map
-var ticker *time.Ticker
-var stopChan chan struct{}
+
+// 正在扫描的输出目标，每个输出目标单独扫描，慢的目标不会阻塞其他目标
+var sinkRunning sync.Map
 
 type logTask struct {
 	logPosition *logPosition
+	sink        string
 	rootPath    string
 	relatePath  string
 	port        string
-	stop        chan struct{}
+	location    *time.Location  // 服务器时区
+	ctx         context.Context // 任务停止时取消，停止读取文件
+	cancel      context.CancelFunc
+	inflight    int32         // 正在处理还没有保存断点的批次
+	scanning    int32         // 正在扫描，扫描协程会修改logPosition
+	ledger      *LedgerEntry  // 当前文件的上报记录
+	grace       time.Duration // 之前日期的文件超过宽限期没有变化才认为写入结束
+	reconcile   bool          // 文件结束时是否对账
+	format      *fileFormat   // 记录分割方式和字符编码
+	closing     *closingFile  // 已经开始扫描下一天，在宽限期内等待写入结束的之前日期的文件
+	restored    bool          // 是否已经从上报记录恢复等待写入结束的文件
+}
+
+// 之前日期等待写入结束的文件，开始扫描下一天后读取位置保存在文件的上报记录中
+type closingFile struct {
+	path     string
+	day      time.Time
+	position int64
+	entry    *LedgerEntry // 文件不存在时为空
 }
 
+// 日志来源信息，随每一批日志传递给消费者
+type LogSource struct {
+	// 运营商
+	Operator int
+
+	// 服务器
+	Server int
+
+	// 端口
+	Port string
+
+	// 日志名称
+	RecordName string
+
+	// 日志类型tlog，flog
+	LogType string
+
+	// 日志文件路径
+	Path string
+
+	// 服务器时区
+	Location *time.Location
+
+	// 处理结果统计，用于记录文件的上报记录
+	Stats *BatchStats
+
+	// 输出目标，默认为空
+	Sink string
+
+	// 每一行在文件中的起始位置
+	Offsets []int64
+
+	// 过期时间策略，为空时使用PastTolerance,PastPolicy配置
+	pastPolicy *timePolicy
+
+	// 当前批次等待写入的死信
+	deadLetters pendingDeadLetters
+}
+
+func newLogTask(position *logPosition, systemConfig *model.AppConfig, serverConfig *model.ServerConfig) *logTask {
+	ctx, cancel := context.WithCancel(context.Background())
+	reconcile, _ := strconv.ParseBool(systemConfig.Reconcile)
+	return &logTask{
+		logPosition: position,
+		sink:        position.Sink,
+		rootPath:    systemConfig.LogRootPath,
+		relatePath:  systemConfig.LogRelatedPath,
+		port:        serverConfig.Port,
+		location:    serverLocation(systemConfig, serverConfig),
+		ctx:         ctx,
+		cancel:      cancel,
+		grace:       completionGrace(systemConfig),
+		reconcile:   reconcile,
+		format:      fileFormatFor(systemConfig, position.Log, position.LogType),
+	}
+}
+
+// 文件结束宽限期，默认5分钟
+func completionGrace(config *model.AppConfig) time.Duration {
+	if len(config.CompletionGrace) == 0 {
+		return 5 * time.Minute
+	}
+	grace, err := time.ParseDuration(config.CompletionGrace)
+	if err != nil || grace < 0 {
+		panic("CompletionGrace配置错误" + config.CompletionGrace)
+	}
+	return grace
+}
+
+// 停止任务，可以重复调用
 func (t *logTask) Close() {
-	t.stop <- struct{}{}
-	close(t.stop)
+	t.cancel()
 }
 
 func (t *logTask) Closed() bool {
-	select {
-	case <-t.stop:
-		return true
-	default:
-		return false
-	}
+	return t.ctx.Err() != nil
 }
 
+// 为每个输出目标注册扫描任务，有新注册的任务时返回true
 func RegisterEvent(systemConfig *model.AppConfig, serverConfig *model.ServerConfig, recordName, logType string) bool {
-	position := LoadOne(serverConfig.Operator, serverConfig.Server, recordName)
+	registered := false
+	for _, sinkName := range SinkNames() {
+		if registerTask(systemConfig, serverConfig, recordName, logType, sinkName) {
+			registered = true
+		}
+	}
+	return registered
+}
+
+func registerTask(systemConfig *model.AppConfig, serverConfig *model.ServerConfig, recordName, logType, sinkName string) bool {
+	id := positionId(serverConfig.Operator, serverConfig.Server, recordName, sinkName)
+	if _, ok := tasks.Load(id); ok {
+		return false
+	}
+	position, err := LoadOne(context.Background(), serverConfig.Operator, serverConfig.Server, recordName, sinkName)
+	if err != nil {
+		panic(id + "加载断点失败" + err.Error())
+	}
 	if position != nil {
-		_, ok := tasks.Load(position.Id)
-		if ok {
-			return false
-		}
-		task := &logTask{
-			logPosition: position,
-			rootPath:    systemConfig.LogRootPath,
-			relatePath:  systemConfig.LogRelatedPath,
-			port:        serverConfig.Port,
-			stop:        make(chan struct{}, 1),
-		}
-		tasks.Store(position.Id, task)
+		tasks.Store(position.Id, newLogTask(position, systemConfig, serverConfig))
 		log.Println("注册任务:", position.String())
 		return true
 	}
-	startDay, err := time.Parse("2006-01-02", systemConfig.StartDay)
+	// 日期统一使用UTC零点表示服务器时区下的自然日
+	startDayConfig := sinks[sinkName].startDay
+	startDay, err := time.Parse("2006-01-02", startDayConfig)
 	if err != nil {
-		panic("日志起始日期配置格式(2006-01-02)错误错误" + systemConfig.StartDay)
+		panic("日志起始日期配置格式(2006-01-02)错误错误" + startDayConfig)
 	}
-	id := fmt.Sprintf("%d_%d_%s", serverConfig.Operator, serverConfig.Server, recordName)
 	position = &logPosition{
 		Id:          id,
 		Operator:    serverConfig.Operator,
@@ -69,119 +164,372 @@ func RegisterEvent(systemConfig *model.AppConfig, serverConfig *model.ServerConf
 		LastExecute: startDay,
 		Position:    0,
 		TotalRows:   0,
+		Sink:        sinkName,
 	}
 	// 插入数据库
-	SaveToDb(position)
+	if err := SaveToDb(context.Background(), position); err != nil {
+		panic(err.Error())
+	}
 
-	tasks.Store(position.Id, &logTask{
-		logPosition: position,
-		rootPath:    systemConfig.LogRootPath,
-		relatePath:  systemConfig.LogRelatedPath,
-		port:        serverConfig.Port,
-		stop:        make(chan struct{}, 1),
-	})
+	tasks.Store(position.Id, newLogTask(position, systemConfig, serverConfig))
 	log.Println("注册任务:", position.String())
 	return true
 }
 
-func StartScanLog(duration time.Duration, process func(recordName string, lines []string)) {
-	ticker = time.NewTicker(duration)
-	stopChan = make(chan struct{})
+// 定时扫描日志，ctx取消后不再开始新一轮扫描，调度结束后关闭返回的channel。
+// 处理函数的ctx由uploadCtx派生，在停止超时后取消，用于放弃正在进行的上报
+func StartScanLog(ctx, uploadCtx context.Context, duration time.Duration, process func(ctx context.Context, source *LogSource, lines []string) error) <-chan struct{} {
+	processCtx, abort := context.WithCancel(uploadCtx)
+	done := make(chan struct{})
+	scheduler.Lock()
+	scheduler.done, scheduler.abort = done, abort
+	scheduler.Unlock()
+	ticker := time.NewTicker(duration)
 	log.Println("启动定时调度任务，时间间隔为", duration)
 	go func() {
+		defer close(done)
+		defer ticker.Stop()
 		for {
 			select {
 			case <-ticker.C:
 				log.Println("开始扫描日志")
-				scanAllLog(process)
-				log.Println("结束扫描")
-			case <-stopChan:
+				scanAllLog(processCtx, process)
+			case <-ctx.Done():
 				log.Println("结束扫描任务调度")
-				tasks.Range(func(key, value interface{}) bool {
-					log.Println(key, "结束扫描")
-					return true
-				})
 				return
 			}
 		}
 	}()
+	return done
 }
 
-func StopScanLog() {
+// 按照输出目标分组扫描，上一轮没有结束的输出目标跳过本轮
+func scanAllLog(ctx context.Context, process func(ctx context.Context, source *LogSource, lines []string) error) {
+	sinkTasks := make(map[string][]*logTask)
 	tasks.Range(func(key, value interface{}) bool {
 		logTask := value.(*logTask)
-		logTask.Close()
+		sinkTasks[logTask.sink] = append(sinkTasks[logTask.sink], logTask)
 		return true
 	})
-	if ticker != nil {
-		ticker.Stop()
+	for sinkName, sinkTask := range sinkTasks {
+		value, _ := sinkRunning.LoadOrStore(sinkName, new(int32))
+		running := value.(*int32)
+		if !atomic.CompareAndSwapInt32(running, 0, 1) {
+			log.Println("输出目标", sinkName, "上一轮扫描没有结束,跳过")
+			continue
+		}
+		scanWait.Add(1)
+		go func(sinkName string, sinkTask []*logTask) {
+			defer scanWait.Done()
+			defer atomic.StoreInt32(running, 0)
+			for _, logTask := range sinkTask {
+				scanOneTask(ctx, logTask, process)
+			}
+			log.Println("输出目标", sinkName, "结束扫描")
+		}(sinkName, sinkTask)
 	}
-	close(stopChan)
-}
-
-func scanAllLog(process func(recordName string, lines []string)) {
-	tasks.Range(func(key, value interface{}) bool {
-		logTask := value.(*logTask)
-		scanOneTask(logTask, process)
-		return true
-	})
 }
 
-func scanOneTask(task *logTask, process func(recordName string, lines []string)) {
+// 扫描一个任务，任务停止后不再读取新的内容，ctx用于上报和保存断点
+func scanOneTask(ctx context.Context, task *logTask, process func(ctx context.Context, source *LogSource, lines []string) error) {
+	// 先标记扫描再检查停止，Shutdown停止任务后看到没有扫描时断点不会再被修改
+	atomic.StoreInt32(&task.scanning, 1)
+	defer atomic.StoreInt32(&task.scanning, 0)
 	if task.Closed() {
 		log.Println(task.logPosition.Id, "任务停止")
 		return
 	}
+	// 进程重启后恢复之前日期等待写入结束的文件
+	if !task.restored {
+		if err := task.restoreClosing(ctx); err != nil {
+			log.Println(task.logPosition.Id, "恢复之前日期的文件失败,等待下一轮重试", err)
+			return
+		}
+	}
+	// 之前日期等待写入结束的文件单独扫描，不影响扫描当天的文件
+	if err := task.scanClosing(ctx, process); err != nil {
+		log.Println(task.logPosition.Id, "处理之前日期的文件失败,等待下一轮重试", err)
+		return
+	}
 	logPosition := task.logPosition
 	lastExecute := logPosition.LastExecute
-	nowStr := time.Now().Format("2006-01-02")
-	now, _ := time.Parse("2006-01-02", nowStr)
+	// 按照服务器时区计算当天日期
+	now := dayIn(time.Now(), task.location)
+	var path string
 	// 处理读取到的行
-	var logProcess = func(position int64, lines []string) {
-		process(task.logPosition.Log, lines)
+	var logProcess = func(position int64, lines []string, offsets []int64) error {
+		source := task.newSource(path, offsets)
+		atomic.AddInt32(&task.inflight, 1)
+		defer atomic.AddInt32(&task.inflight, -1)
+		entry, err := task.ledgerFor(ctx, path)
+		if err != nil {
+			return err
+		}
+		if err := process(ctx, source, lines); err != nil {
+			return err
+		}
 		logPosition.Position = position
 		logPosition.TotalRows += len(lines)
-		SaveToDb(logPosition)
+		entry.record(position, len(lines), source.Stats, time.Now())
+		if err := saveCheckpoint(ctx, logPosition, entry); err != nil {
+			return err
+		}
+		source.flushDeadLetters()
+		return nil
 	}
-	var stop = func() bool { return task.Closed() }
 	// 如果是前一天
 	for ; !lastExecute.After(now); lastExecute = lastExecute.Add(24 * time.Hour) {
 		if task.Closed() {
 			log.Println(task.logPosition.Id, "任务停止")
 			return
 		}
-		sep := string([]byte{os.PathSeparator})
-		path := fmt.Sprintf("%s%s%s%s%s%s%s%s%d_%d_%s.%s",
-			task.rootPath,
-			sep,
-			task.port,
-			sep,
-			task.relatePath,
-			sep,
-			logPosition.LogType,
-			sep,
-			logPosition.Operator,
-			logPosition.Server,
-			logPosition.Log,
-			lastExecute.Format("2006-01-02"))
+		path = logFilePath(task.rootPath, task.port, task.relatePath, logPosition.LogType, logPosition.Operator, logPosition.Server, logPosition.Log, lastExecute)
 
 		// 更新扫描的日期
 		if lastExecute != logPosition.LastExecute {
 			logPosition.LastExecute = lastExecute
 			logPosition.Position = 0
-			SaveToDb(logPosition)
+			if err := saveCheckpoint(ctx, logPosition, nil); err != nil {
+				log.Println(task.logPosition.Id, "保存断点失败,等待下一轮重试", err)
+				return
+			}
+		}
+		// 扫描文件，处理失败时保留断点，下一轮从失败的位置重新处理
+		// 之前日期的文件超过宽限期没有变化时，输出文件末尾没有结束符的记录
+		final := lastExecute.Before(now) && task.idle(path)
+		if err := scanFile(task.ctx, path, logPosition.Position, task.format, final, logProcess); err != nil {
+			log.Println(task.logPosition.Id, "处理失败,等待下一轮重试", err)
+			return
+		}
+		if !lastExecute.Before(now) || task.Closed() {
+			return
+		}
+		// 之前日期的文件结束后标记上报完成，没有结束时在宽限期内单独关闭，继续扫描下一天
+		file := &closingFile{path: path, day: lastExecute, position: logPosition.Position}
+		closed, err := task.completeFile(ctx, file)
+		if err != nil {
+			log.Println(task.logPosition.Id, "保存上报记录失败,等待下一轮重试", err)
+			return
+		}
+		if closed {
+			continue
+		}
+		if task.closing != nil {
+			log.Println(task.logPosition.Id, "等待文件写入结束", task.closing.path)
+			return
+		}
+		if err := task.waitClosing(ctx, file); err != nil {
+			log.Println(task.logPosition.Id, "保存上报记录失败,等待下一轮重试", err)
+			return
 		}
-		// 扫描文件
-		scanFile(path, logPosition.Position, logProcess, stop)
 	}
 }
 
-func scanFile(path string, position int64, process func(position int64, lines []string), stop func() bool) {
+func (t *logTask) newSource(path string, offsets []int64) *LogSource {
+	return &LogSource{
+		Operator:   t.logPosition.Operator,
+		Server:     t.logPosition.Server,
+		Port:       t.port,
+		RecordName: t.logPosition.Log,
+		LogType:    t.logPosition.LogType,
+		Path:       path,
+		Location:   t.location,
+		Sink:       t.sink,
+		Offsets:    offsets,
+		Stats:      newBatchStats(),
+	}
+}
+
+// 之前日期的文件已经读取到末尾但是还在宽限期内，单独等待写入结束，断点保存在文件的上报记录中
+func (t *logTask) waitClosing(ctx context.Context, file *closingFile) error {
+	if _, err := os.Stat(file.path); err == nil {
+		entry, err := t.closingLedger(ctx, file)
+		if err != nil {
+			return err
+		}
+		entry.Position = file.position
+		if err := saveCheckpoint(ctx, t.logPosition, entry); err != nil {
+			return err
+		}
+	}
+	t.closing = file
+	log.Println(t.logPosition.Id, "等待文件写入结束,开始扫描下一天", file.path)
+	return nil
+}
+
+// 进程重启后从上报记录恢复前一天没有结束的文件
+func (t *logTask) restoreClosing(ctx context.Context) error {
+	day := t.logPosition.LastExecute.Add(-24 * time.Hour)
+	entry, err := loadLedger(ctx, ledgerId(t.logPosition.Id, day.Format("2006-01-02")))
+	if err != nil {
+		return err
+	}
+	t.restored = true
+	if entry != nil && !entry.Completed {
+		t.closing = &closingFile{path: entry.Path, day: day, position: entry.Position, entry: entry}
+	}
+	return nil
+}
+
+// 扫描等待写入结束的文件中新写入的内容，超过宽限期没有变化时标记上报完成
+func (t *logTask) scanClosing(ctx context.Context, process func(ctx context.Context, source *LogSource, lines []string) error) error {
+	file := t.closing
+	if file == nil {
+		return nil
+	}
+	err := scanFile(t.ctx, file.path, file.position, t.format, t.idle(file.path), func(position int64, lines []string, offsets []int64) error {
+		source := t.newSource(file.path, offsets)
+		atomic.AddInt32(&t.inflight, 1)
+		defer atomic.AddInt32(&t.inflight, -1)
+		entry, err := t.closingLedger(ctx, file)
+		if err != nil {
+			return err
+		}
+		if err := process(ctx, source, lines); err != nil {
+			return err
+		}
+		file.position = position
+		t.logPosition.TotalRows += len(lines)
+		entry.record(position, len(lines), source.Stats, time.Now())
+		if err := saveCheckpoint(ctx, t.logPosition, entry); err != nil {
+			return err
+		}
+		source.flushDeadLetters()
+		return nil
+	})
+	if err != nil || t.Closed() {
+		return err
+	}
+	closed, err := t.completeFile(ctx, file)
+	if err == nil && closed {
+		t.closing = nil
+	}
+	return err
+}
+
+// 当前文件的上报记录，切换日期后从数据库加载
+func (t *logTask) ledgerFor(ctx context.Context, path string) (*LedgerEntry, error) {
+	day := t.logPosition.LastExecute.Format("2006-01-02")
+	if t.ledger != nil && t.ledger.Day == day {
+		return t.ledger, nil
+	}
+	entry, err := loadLedger(ctx, ledgerId(t.logPosition.Id, day))
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		entry = newLedgerEntry(t.logPosition, day, path)
+	}
+	t.ledger = entry
+	return entry, nil
+}
+
+// 文件超过宽限期没有变化
+func (t *logTask) idle(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && time.Since(info.ModTime()) >= t.grace
+}
+
+// 之前日期文件的上报记录，还在扫描这一天时就是当前文件的上报记录
+func (t *logTask) closingLedger(ctx context.Context, file *closingFile) (*LedgerEntry, error) {
+	if file.entry != nil {
+		return file.entry, nil
+	}
+	var entry *LedgerEntry
+	var err error
+	if file.day.Equal(t.logPosition.LastExecute) {
+		entry, err = t.ledgerFor(ctx, file.path)
+	} else if entry, err = loadLedger(ctx, ledgerId(t.logPosition.Id, file.day.Format("2006-01-02"))); err == nil && entry == nil {
+		entry = newLedgerEntry(t.logPosition, file.day.Format("2006-01-02"), file.path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	file.entry = entry
+	return entry, nil
+}
+
+// 检查之前日期的文件是否结束，文件全部读取并且超过宽限期没有变化时标记上报完成。
+// 文件不存在时，超过日期结束时间加宽限期认为结束
+func (t *logTask) completeFile(ctx context.Context, file *closingFile) (bool, error) {
+	info, err := os.Stat(file.path)
+	if err != nil {
+		day := file.day
+		dayEnd := time.Date(day.Year(), day.Month(), day.Day()+1, 0, 0, 0, 0, locationOrLocal(t.location))
+		return time.Since(dayEnd) >= t.grace, nil
+	}
+	if file.position < info.Size() || time.Since(info.ModTime()) < t.grace {
+		return false, nil
+	}
+	entry, err := t.closingLedger(ctx, file)
+	if err != nil || entry.Completed {
+		return err == nil, err
+	}
+	entry.complete()
+	if t.reconcile {
+		fileLines, err := countLines(file.path, t.format)
+		if err != nil {
+			return false, err
+		}
+		entry.reconcile(fileLines)
+		if entry.Reconcile != "ok" {
+			addMetric(reconcileMetrics, t.logPosition.Log, 1)
+			log.Println(entry.Id, "对账不一致", entry.Reconcile)
+		}
+	}
+	log.Println(entry.Id, "上报完成,读取行数", entry.Lines, "上报行数", entry.Sent, "过滤行数", entry.Filtered, "忽视行数", entry.Dropped)
+	return true, saveCheckpoint(ctx, t.logPosition, entry)
+}
+
+// 对账不一致的文件数量，key为日志名
+const reconcileMetrics = "reconcile_mismatch"
+
+// 按照扫描的规则统计文件行数，空行不计数
+func countLines(path string, format *fileFormat) (int64, error) {
+	var count int64
+	err := scanFile(context.Background(), path, 0, format, true, func(position int64, lines []string, offsets []int64) error {
+		count += int64(len(lines))
+		return nil
+	})
+	return count, err
+}
+
+// 日志文件路径 根路径/port/相对路径/[tlog|flog]/1_1_LogType.yyyy-MM-dd
+func logFilePath(rootPath, port, relatePath, logType string, operator, server int, recordName string, day time.Time) string {
+	sep := string([]byte{os.PathSeparator})
+	return fmt.Sprintf("%s%s%s%s%s%s%s%s%d_%d_%s.%s",
+		rootPath,
+		sep,
+		port,
+		sep,
+		relatePath,
+		sep,
+		logType,
+		sep,
+		operator,
+		server,
+		recordName,
+		day.Format("2006-01-02"))
+}
+
+// 扫描文件读取缓冲区大小，记录超过缓冲区时扩容
+const scanBufferSize = 128 * 1024
+
+// 复用扫描文件的缓冲区
+var scanBuffers = sync.Pool{New: func() interface{} {
+	buffer := make([]byte, 0, scanBufferSize)
+	return &buffer
+}}
+
+// 扫描文件，ctx取消后不再读取新的内容，处理函数返回错误时停止扫描并返回错误。
+// 按照format切分记录并转换为utf-8，final为false时文件末尾没有结束的记录留到下一次扫描。
+// 每一批复用lines和offsets，process不能保留这两个切片
+func scanFile(ctx context.Context, path string, position int64, format *fileFormat, final bool, process func(position int64, lines []string, offsets []int64) error) error {
 	file, err := os.Open(path)
 	if err != nil {
 		if _, ok := err.(*os.PathError); ok {
 			log.Println(path, "不存在")
-			return
+			return nil
 		}
 		panic(err.Error())
 	}
@@ -191,8 +539,6 @@ func scanFile(path string, position int64, process func(position int64, lines []
 			log.Println("关闭文件句柄错误", path, err.Error())
 		}
 	}()
-	buffer := bytes.NewBuffer(nil)
-	cache := make([]byte, 128*1024)
 	var offset = position
 	if offset < 0 {
 		offset = 0
@@ -200,71 +546,113 @@ func scanFile(path string, position int64, process func(position int64, lines []
 	ret, err := file.Seek(offset, 0)
 	if err != nil {
 		log.Panic(path, "Seek失败", err)
-		return
+		return nil
 	}
 	if ret != offset {
 		log.Panic(path, offset, ret, "seed位置错误")
 	}
-	if stop() {
+	if ctx.Err() != nil {
 		log.Println(path, "任务停止")
-		return
+		return nil
 	}
+	// 还没有处理的内容，直接读取到缓冲区的空闲部分
+	buffer := scanBuffers.Get().(*[]byte)
+	pending := (*buffer)[:0]
+	defer func() {
+		if cap(pending) <= 4*scanBufferSize {
+			*buffer = pending[:0]
+			scanBuffers.Put(buffer)
+		}
+	}()
+	batch := &recordBatch{transcode: format.transcoder(), utf8: format.encoding == nil}
 	for {
-		read, err := file.Read(cache)
-		if read <= 0 {
-			if err != nil {
-				if err == io.EOF {
-					break
-				}
-				panic(err.Error())
-			}
+		if len(pending) == cap(pending) {
+			// 一条记录超过缓冲区大小
+			grown := make([]byte, len(pending), 2*cap(pending))
+			copy(grown, pending)
+			pending = grown
+		}
+		read, err := file.Read(pending[len(pending):cap(pending)])
+		if err != nil && err != io.EOF {
+			panic(err.Error())
+		}
+		eof := read <= 0
+		// 文件末尾只有在final时才输出没有结束的记录，断点始终停在记录边界
+		if eof && (!final || len(pending) == 0) {
 			break
 		}
-		start := 0
-		cur := 0
-		lines := make([]string, 0, 1)
-		for i := 0; i < read; i++ {
-			b := cache[i]
-			// \n\r
-			if b != '\r' && b != '\n' {
-				continue
-			}
-			cur = i
-
-			size := cur - start
-			if buffer.Len() > 0 {
-				size += buffer.Len()
+		pending = pending[:len(pending)+read]
+		batch.reset()
+		consumed := 0
+		for consumed < len(pending) {
+			advance, start, end, err := format.framer.split(pending[consumed:], eof)
+			if err != nil {
+				return fmt.Errorf("%s位置%d切分记录失败:%s", path, offset+int64(consumed), err.Error())
 			}
-			if size == 0 {
-				// 排除当前字节（因为是换行符）
-				start = i + 1
-				continue
+			if advance == 0 {
+				break
 			}
-			if buffer.Len() > 0 {
-				buffer.Write(cache[start:cur])
-				line := buffer.String()
-				lines = append(lines, line)
-				buffer.Reset()
-			} else {
-				line := string(cache[start:cur])
-				lines = append(lines, line)
+			if end > start {
+				batch.add(consumed+start, consumed+end)
 			}
-			start = i + 1
-		}
-		if start < read {
-			buffer.Write(cache[start:read])
+			consumed += advance
 		}
+		lines, offsets := batch.build(pending[:consumed], offset)
 		// 处理这一批
-		process(offset+int64(start), lines)
-		offset += int64(read)
-		if stop() {
+		if err := process(offset+int64(consumed), lines, offsets); err != nil {
+			return err
+		}
+		offset += int64(consumed)
+		pending = pending[:copy(pending, pending[consumed:])]
+		if eof {
+			break
+		}
+		if ctx.Err() != nil {
 			log.Println(path, "任务停止")
-			return
+			return nil
 		}
 	}
-	if buffer.Len() > 0 {
-		line := buffer.String()
-		log.Println(path, "文件没有结束符[", line, "]")
-		process(offset, []string{line})
+	return nil
+}
+
+// 一批记录在缓冲区中的位置，转换为字符串时复用切片
+type recordBatch struct {
+	transcode func(record []byte) string
+	utf8      bool // 文件编码是否为utf-8
+	bounds    []int
+	lines     []string
+	offsets   []int64
+}
+
+func (b *recordBatch) reset() {
+	b.bounds = b.bounds[:0]
+	b.lines = b.lines[:0]
+	b.offsets = b.offsets[:0]
+}
+
+func (b *recordBatch) add(start, end int) {
+	b.bounds = append(b.bounds, start, end)
+}
+
+// utf-8内容整批转换为一个字符串，每条记录是其中的子串，只分配一次内存；
+// 其他编码或者有无效字节时逐条转换
+func (b *recordBatch) build(data []byte, offset int64) ([]string, []int64) {
+	if len(b.bounds) == 0 {
+		return b.lines, b.offsets
+	}
+	whole := b.utf8 && utf8.Valid(data)
+	var text string
+	if whole {
+		text = string(data)
+	}
+	for i := 0; i < len(b.bounds); i += 2 {
+		start, end := b.bounds[i], b.bounds[i+1]
+		if whole {
+			b.lines = append(b.lines, text[start:end])
+		} else {
+			b.lines = append(b.lines, b.transcode(data[start:end]))
+		}
+		b.offsets = append(b.offsets, offset+int64(start))
 	}
+	return b.lines, b.offsets
 }
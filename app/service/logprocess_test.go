@@ -2,10 +2,15 @@ package service
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -114,3 +119,116 @@ func Test_FileSeek(t *testing.T) {
 		panic("剩余内容" + buffer.String())
 	}
 }
+
+func TestScanFileOffsets(t *testing.T) {
+	path := "test/SmallFile.log"
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	total := 0
+	err = scanFile(context.Background(), path, 0, &fileFormat{framer: &lineFramer{}}, true, func(position int64, lines []string, offsets []int64) error {
+		if len(lines) != len(offsets) {
+			t.Fatal("行数与位置数量不一致", len(lines), len(offsets))
+		}
+		for i, line := range lines {
+			offset := offsets[i]
+			if string(content[offset:offset+int64(len(line))]) != line {
+				t.Fatal("行位置错误", offset, line)
+			}
+		}
+		total += len(lines)
+		return nil
+	})
+	if err != nil || total == 0 {
+		t.Fatal("没有读取到数据")
+	}
+}
+
+func TestScanFileStopOnError(t *testing.T) {
+	calls := 0
+	err := scanFile(context.Background(), "test/SmallFile.log", 0, &fileFormat{framer: &lineFramer{}}, true, func(position int64, lines []string, offsets []int64) error {
+		calls++
+		return errors.New("上报失败")
+	})
+	if err == nil || calls != 1 {
+		t.Fatal("处理失败时应该停止扫描", calls, err)
+	}
+}
+
+func TestScanFileCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := scanFile(ctx, "test/SmallFile.log", 0, &fileFormat{framer: &lineFramer{}}, true, func(position int64, lines []string, offsets []int64) error {
+		t.Fatal("任务停止后不应该读取文件")
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 游戏服单个日志文件一般在几十MB
+const benchmarkFileSize = 32 * 1024 * 1024
+
+// 生成tab分割的测试日志，每行30列
+func writeBenchmarkLog(b *testing.B) (string, func()) {
+	dir, err := ioutil.TempDir("", "bench")
+	if err != nil {
+		b.Fatal(err)
+	}
+	path := filepath.Join(dir, "1_1_ItemRecord.2021-05-02")
+	builder := strings.Builder{}
+	for i := 0; builder.Len() < benchmarkFileSize; i++ {
+		builder.WriteString(benchmarkLine(i))
+		builder.WriteByte('\n')
+	}
+	if err := ioutil.WriteFile(path, []byte(builder.String()), 0644); err != nil {
+		b.Fatal(err)
+	}
+	return path, func() { _ = os.RemoveAll(dir) }
+}
+
+func benchmarkLine(i int) string {
+	cols := make([]string, 30)
+	for c := range cols {
+		cols[c] = fmt.Sprintf("col%d_%d", c, i)
+	}
+	cols[0] = "2021-05-02 12:00:00"
+	return strings.Join(cols, "\t")
+}
+
+func BenchmarkScanFile(b *testing.B) {
+	path, cleanup := writeBenchmarkLog(b)
+	defer cleanup()
+	format := &fileFormat{framer: &lineFramer{}}
+	b.SetBytes(benchmarkFileSize)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		lines := 0
+		err := scanFile(context.Background(), path, 0, format, true, func(position int64, batch []string, offsets []int64) error {
+			lines += len(batch)
+			return nil
+		})
+		if err != nil || lines == 0 {
+			b.Fatal("读取失败", err)
+		}
+	}
+}
+
+func BenchmarkDecodeLines(b *testing.B) {
+	lines := make([]string, 5000)
+	offsets := make([]int64, len(lines))
+	for i := range lines {
+		lines[i] = benchmarkLine(i)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rows := decodeLines("tsv", lines, offsets)
+		if value, ok := rows[len(rows)-1].ByIndex(30); !ok || len(value) == 0 {
+			b.Fatal("解码失败")
+		}
+	}
+}
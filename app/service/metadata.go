@@ -0,0 +1,112 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"xai.com/shushu/app/model"
+)
+
+// 程序版本，构建时通过 -ldflags "-X xai.com/shushu/app/service.Version=xxx" 设置
+var Version = "dev"
+
+// 来源属性默认的属性名前缀
+const metadataPrefix = "src_"
+
+// 支持的来源属性
+var metadataNames = map[string]bool{
+	"operator":    true, // 运营商
+	"server":      true, // 服务器
+	"port":        true, // 端口
+	"record":      true, // 日志名称
+	"file":        true, // 日志文件名
+	"offset":      true, // 当前行在文件中的位置
+	"host":        true, // 上报程序所在机器
+	"version":     true, // 上报程序版本
+	"ingest_time": true, // 上报程序读取的时间
+}
+
+type metadataProperty struct {
+	name     string // 来源属性
+	property string // 上报的属性名
+}
+
+var (
+	hostName       string
+	sourceMetadata []metadataProperty
+)
+
+// 初始化来源属性配置
+func initSourceMetadata(config string) {
+	properties, err := parseSourceMetadata(config)
+	if err != nil {
+		panic("SourceMetadata配置错误:" + err.Error())
+	}
+	sourceMetadata = properties
+	hostName, _ = os.Hostname()
+}
+
+// 解析来源属性配置，格式 operator,server,file:log_file，不配置属性名时使用src_前缀
+func parseSourceMetadata(config string) ([]metadataProperty, error) {
+	properties := make([]metadataProperty, 0)
+	for _, item := range strings.Split(config, ",") {
+		item = strings.TrimSpace(item)
+		if len(item) == 0 {
+			continue
+		}
+		name, property := item, metadataPrefix+item
+		if index := strings.Index(item, ":"); index >= 0 {
+			name, property = strings.TrimSpace(item[:index]), strings.TrimSpace(item[index+1:])
+		}
+		if !metadataNames[name] {
+			return nil, fmt.Errorf("不支持的来源属性[%s]", name)
+		}
+		if len(property) == 0 || strings.HasPrefix(property, "#") {
+			return nil, fmt.Errorf("来源属性[%s]的属性名[%s]错误", name, property)
+		}
+		properties = append(properties, metadataProperty{name: name, property: property})
+	}
+	return properties, nil
+}
+
+// 事件和user_set,user_setOnce添加来源属性。
+// user_add会累加数值属性(如运营商),user_append要求列表属性,user_unset会删除这些属性,user_del没有属性，都不添加
+func metadataApplies(uploadType string) bool {
+	switch uploadType {
+	case model.UploadUserSet, model.UploadUserSetOnce:
+		return true
+	}
+	return model.IsTrackType(uploadType)
+}
+
+// 为上报数据添加来源属性
+func applySourceMetadata(eventConfig *model.EventConfig, source *LogSource, row *logRow, values *uploadRow) {
+	if len(sourceMetadata) == 0 || source == nil || !metadataApplies(eventConfig.UploadType) {
+		return
+	}
+	properties := &values.properties
+	for _, metadata := range sourceMetadata {
+		switch metadata.name {
+		case "operator":
+			properties.set(metadata.property, source.Operator)
+		case "server":
+			properties.set(metadata.property, source.Server)
+		case "port":
+			properties.set(metadata.property, source.Port)
+		case "record":
+			properties.set(metadata.property, source.RecordName)
+		case "file":
+			properties.set(metadata.property, filepath.Base(source.Path))
+		case "offset":
+			properties.set(metadata.property, row.offset)
+		case "host":
+			properties.set(metadata.property, hostName)
+		case "version":
+			properties.set(metadata.property, Version)
+		case "ingest_time":
+			properties.set(metadata.property, time.Now().Format("2006-01-02 15:04:05.000"))
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package service
+
+import (
+	"testing"
+	"xai.com/shushu/app/model"
+)
+
+func TestParseSourceMetadata(t *testing.T) {
+	properties, err := parseSourceMetadata("operator, file:log_file,offset")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(properties) != 3 || properties[0].property != "src_operator" || properties[1].property != "log_file" {
+		t.Fatal("来源属性解析错误", properties)
+	}
+	for _, config := range []string{"unknown", "file:#file", "file:"} {
+		if _, err = parseSourceMetadata(config); err == nil {
+			t.Fatal(config, "应该解析失败")
+		}
+	}
+}
+
+func TestApplySourceMetadata(t *testing.T) {
+	initSourceMetadata("operator,server,record,file,offset,version")
+	defer initSourceMetadata("")
+	source := &LogSource{Operator: 3, Server: 12, RecordName: "ItemRecord", Path: "/data/8001/logs/tlog/3_12_ItemRecord.2021-05-02", Offsets: []int64{0, 120}}
+	rows := decodeLines("tsv", []string{"a\tb", "c\td"}, source.Offsets)
+	values := newUploadRow(0, 0)
+	applySourceMetadata(&model.EventConfig{UploadType: model.UploadTrack}, source, rows[1], values)
+	property := func(name string) interface{} {
+		value, _ := values.properties.get(name)
+		return value
+	}
+	if property("src_operator") != 3 || property("src_server") != 12 || property("src_record") != "ItemRecord" ||
+		property("src_file") != "3_12_ItemRecord.2021-05-02" || property("src_offset") != int64(120) || property("src_version") != Version {
+		t.Fatal("来源属性错误", values)
+	}
+	for _, uploadType := range []string{model.UploadUserSet, model.UploadUserSetOnce} {
+		values = newUploadRow(0, 0)
+		applySourceMetadata(&model.EventConfig{UploadType: uploadType}, source, rows[0], values)
+		if property("src_operator") != 3 || property("src_offset") != int64(0) {
+			t.Fatal(uploadType, "应该添加来源属性", values)
+		}
+	}
+	for _, uploadType := range []string{model.UploadUserAdd, model.UploadUserAppend, model.UploadUserUnset, model.UploadUserDel} {
+		values = newUploadRow(0, 0)
+		applySourceMetadata(&model.EventConfig{UploadType: uploadType}, source, rows[0], values)
+		if len(values.fields) != 0 || len(values.properties) != 0 {
+			t.Fatal(uploadType, "不能添加来源属性", values)
+		}
+	}
+}
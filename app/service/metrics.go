@@ -0,0 +1,38 @@
+package service
+
+import (
+	"expvar"
+	"sync"
+)
+
+// 运行指标,通过pprof监听端口的/debug/vars查看
+var (
+	metricsLock sync.Mutex
+	metricsMaps = make(map[string]*expvar.Map)
+)
+
+// 获取指标分组，不存在时创建
+func metricsMap(name string) *expvar.Map {
+	metricsLock.Lock()
+	defer metricsLock.Unlock()
+	m := metricsMaps[name]
+	if m == nil {
+		m = expvar.NewMap(name)
+		metricsMaps[name] = m
+	}
+	return m
+}
+
+// 累加指标
+func addMetric(name, key string, delta int64) {
+	metricsMap(name).Add(key, delta)
+}
+
+// 获取指标当前值
+func getMetric(name, key string) int64 {
+	value, ok := metricsMap(name).Get(key).(*expvar.Int)
+	if !ok {
+		return 0
+	}
+	return value.Value()
+}
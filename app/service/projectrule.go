@@ -0,0 +1,169 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+	"xai.com/shushu/app/model"
+)
+
+// 项目规则类型
+const (
+	ruleOverride   = "override"    // 覆盖字段原始值,如server统一为9999
+	ruleAccountId  = "account_id"  // 转换#account_id
+	ruleDistinctId = "distinct_id" // 生成#distinct_id
+	ruleProperty   = "property"    // 生成属性,如userId
+)
+
+var (
+	fieldOverrides map[string]*model.ProjectRule
+	accountRules   []*model.ProjectRule
+	distinctRules  []*model.ProjectRule
+	propertyRules  []*model.ProjectRule
+)
+
+// 加载项目规则配置，路径为空时不使用任何规则
+func LoadProjectRules(path string) {
+	overrides := make(map[string]*model.ProjectRule)
+	accounts := make([]*model.ProjectRule, 0)
+	distincts := make([]*model.ProjectRule, 0)
+	properties := make([]*model.ProjectRule, 0)
+	if len(path) > 0 {
+		ruleStorage := NewStorage(reflect.TypeOf(model.ProjectRule{}))
+		ruleStorage.Load(path)
+		all := ruleStorage.GetAll()
+		rules := make([]*model.ProjectRule, 0, len(all))
+		for _, item := range all {
+			rules = append(rules, item.(*model.ProjectRule))
+		}
+		sort.Slice(rules, func(i, j int) bool { return rules[i].Id < rules[j].Id })
+		for _, rule := range rules {
+			if err := validateProjectRule(rule); err != nil {
+				panic("项目规则配置错误" + path + ":" + err.Error())
+			}
+			switch rule.Kind {
+			case ruleOverride:
+				overrides[rule.Target] = rule
+			case ruleAccountId:
+				accounts = append(accounts, rule)
+			case ruleDistinctId:
+				distincts = append(distincts, rule)
+			case ruleProperty:
+				properties = append(properties, rule)
+			}
+		}
+		log.Println("加载项目规则", path, "数量", len(rules))
+	}
+	fieldOverrides = overrides
+	accountRules = accounts
+	distinctRules = distincts
+	propertyRules = properties
+}
+
+func validateProjectRule(rule *model.ProjectRule) error {
+	switch rule.Kind {
+	case ruleOverride, ruleProperty:
+		if len(rule.Target) == 0 {
+			return fmt.Errorf("规则[%d]缺少目标字段", rule.Id)
+		}
+	case ruleAccountId, ruleDistinctId:
+	default:
+		return fmt.Errorf("规则[%d]不支持的类型[%s]", rule.Id, rule.Kind)
+	}
+	for _, uploadType := range ruleUploadTypes(rule) {
+		if !model.IsTrackType(uploadType) && !model.IsUserType(uploadType) {
+			return fmt.Errorf("规则[%d]不支持的上报类型[%s]", rule.Id, uploadType)
+		}
+	}
+	if _, err := compileExpression(rule.Expression); err != nil {
+		return fmt.Errorf("规则[%d]%s", rule.Id, err.Error())
+	}
+	return nil
+}
+
+func ruleUploadTypes(rule *model.ProjectRule) []string {
+	if len(strings.TrimSpace(rule.UploadType)) == 0 {
+		return nil
+	}
+	uploadTypes := strings.Split(rule.UploadType, ",")
+	for i, uploadType := range uploadTypes {
+		uploadTypes[i] = strings.TrimSpace(uploadType)
+	}
+	return uploadTypes
+}
+
+// 规则是否对当前上报类型生效
+func ruleMatches(rule *model.ProjectRule, uploadType string) bool {
+	uploadTypes := ruleUploadTypes(rule)
+	if len(uploadTypes) == 0 {
+		return true
+	}
+	for _, v := range uploadTypes {
+		if v == uploadType {
+			return true
+		}
+	}
+	return false
+}
+
+// 字段原始值覆盖规则
+func overrideField(eventConfig *model.EventConfig, name string, row *logRow) (string, bool) {
+	rule := fieldOverrides[name]
+	if rule == nil || !ruleMatches(rule, eventConfig.UploadType) {
+		return "", false
+	}
+	value, err := mustExpression(rule.Expression).EvalString(&exprContext{row: row})
+	if err != nil {
+		log.Println(eventConfig.Name, "字段覆盖规则", rule.Id, "计算失败", err)
+		return "", false
+	}
+	return value, true
+}
+
+// 按照顺序执行账号转换、访客id、属性规则，并将账号统一为字符串
+func applyProjectRules(eventConfig *model.EventConfig, row *logRow, values *uploadRow) {
+	normalizeId(values, "#account_id")
+	normalizeId(values, "#distinct_id")
+	ctx := &exprContext{row: row, values: values}
+	for _, rule := range accountRules {
+		if value, ok := evalRule(eventConfig, rule, ctx); ok {
+			values.fields.set("#account_id", value)
+		}
+	}
+	for _, rule := range distinctRules {
+		if value, ok := evalRule(eventConfig, rule, ctx); ok {
+			values.fields.set("#distinct_id", value)
+		}
+	}
+	for _, rule := range propertyRules {
+		if value, ok := evalRule(eventConfig, rule, ctx); ok {
+			values.properties.set(rule.Target, value)
+		}
+	}
+}
+
+// 计算规则，结果为空时不生效
+func evalRule(eventConfig *model.EventConfig, rule *model.ProjectRule, ctx *exprContext) (string, bool) {
+	if !ruleMatches(rule, eventConfig.UploadType) {
+		return "", false
+	}
+	value, err := mustExpression(rule.Expression).EvalString(ctx)
+	if err != nil {
+		log.Println(eventConfig.Name, "项目规则", rule.Id, "计算失败", err)
+		return "", false
+	}
+	return value, len(value) > 0
+}
+
+// 数数要求账号和访客id为字符串
+func normalizeId(values *uploadRow, name string) {
+	value, ok := values.fields.get(name)
+	if !ok || value == nil {
+		return
+	}
+	if _, ok = value.(string); !ok {
+		values.fields.set(name, toString(value))
+	}
+}
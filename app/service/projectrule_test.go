@@ -0,0 +1,87 @@
+package service
+
+import (
+	"strings"
+	"testing"
+	"xai.com/shushu/app/model"
+)
+
+func TestProjectRules(t *testing.T) {
+	LoadProjectRules("../../config/ProjectRule.xlsx")
+	defer LoadProjectRules("")
+
+	decoder, _ := NewLineDecoder("tsv")
+	row, _ := decoder.Decode("1\t12\t100")
+	track := &model.EventConfig{Name: "item_record", UploadType: model.UploadTrack}
+	if value, ok := overrideField(track, "server", row); !ok || value != "9999" {
+		t.Fatal("server覆盖规则错误", value)
+	}
+	if _, ok := overrideField(track, "operator", row); ok {
+		t.Fatal("operator没有覆盖规则")
+	}
+
+	values := testUploadRow(map[string]interface{}{"#account_id": "robot-2-61.1_1"}, nil)
+	applyProjectRules(track, row, values)
+	if userId, _ := values.properties.get("userId"); userId != "robot-2-61" {
+		t.Fatal("userId规则错误", values)
+	}
+
+	// 账号配置为int时转换为字符串
+	values = testUploadRow(map[string]interface{}{"#account_id": int64(10086)}, nil)
+	applyProjectRules(&model.EventConfig{UploadType: model.UploadUserSet}, row, values)
+	if account, _ := values.fields.get("#account_id"); account != "10086" || values.String() != `{"#account_id":"10086","properties":{"userId":"10086"}}` {
+		t.Fatal("int账号规则错误", values)
+	}
+
+	values = testUploadRow(map[string]interface{}{"#account_id": "acc_1"}, nil)
+	applyProjectRules(&model.EventConfig{UploadType: model.UploadUserAdd}, row, values)
+	if len(values.properties) != 0 {
+		t.Fatal("user_add不能生成userId", values)
+	}
+}
+
+// 项目规则表中的userId规则与之前代码中的计算方式一致
+func TestProjectRuleUserId(t *testing.T) {
+	LoadProjectRules("../../config/ProjectRule.xlsx")
+	defer LoadProjectRules("")
+	legacy := func(account string) string {
+		if account == "" || "-1" == account {
+			return ""
+		}
+		if index := strings.LastIndex(account, "."); index >= 0 {
+			account = account[0:index]
+		}
+		if indexOf := strings.LastIndex(account, "_"); indexOf > 0 {
+			return account[0:indexOf]
+		}
+		return account
+	}
+	decoder, _ := NewLineDecoder("tsv")
+	row, _ := decoder.Decode("1\t12\t100")
+	track := &model.EventConfig{Name: "item_record", UploadType: model.UploadTrack}
+	for _, account := range []string{"robot-2-61.1_1", "acc_1_2", "_acc", "acc.1", "plain", "-1", "", "0"} {
+		values := testUploadRow(map[string]interface{}{"#account_id": account}, nil)
+		applyProjectRules(track, row, values)
+		userId, _ := values.properties.get("userId")
+		if toString(userId) != legacy(account) {
+			t.Fatal(account, "计算结果", userId, "期望", legacy(account))
+		}
+	}
+}
+
+func TestValidateProjectRule(t *testing.T) {
+	rules := []*model.ProjectRule{
+		{Id: 1, Kind: "unknown", Expression: `"1"`},
+		{Id: 2, Kind: ruleProperty, Expression: `"1"`},
+		{Id: 3, Kind: ruleAccountId, Expression: `concat(`},
+		{Id: 4, Kind: ruleDistinctId, Expression: `$1`, UploadType: "track,user_xx"},
+	}
+	for _, rule := range rules {
+		if validateProjectRule(rule) == nil {
+			t.Fatal(rule.Id, "应该校验失败")
+		}
+	}
+	if err := validateProjectRule(&model.ProjectRule{Id: 5, Kind: ruleDistinctId, Expression: `concat("d_", $3)`, UploadType: "track, user_set"}); err != nil {
+		t.Fatal(err)
+	}
+}
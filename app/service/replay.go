@@ -0,0 +1,305 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+	"xai.com/shushu/app/model"
+)
+
+// 重新上报历史日志的参数
+type ReplayOptions struct {
+	// 任务名，相同任务名重复执行时从上次的进度继续
+	Job string
+
+	// 日期范围，包含开始和结束日期
+	From time.Time
+	To   time.Time
+
+	// 运营商，为空时所有运营商
+	Operators map[int]bool
+
+	// 服务器范围，为空时所有服务器
+	Servers [][2]int
+
+	// 日志名称，为空时所有日志
+	Records map[string]bool
+
+	// 输出目标
+	Sink string
+
+	// 每秒最多处理的行数，0不限制
+	Rate int
+
+	// 过期时间策略，默认不检查，历史日志不受实时上报的PastTolerance,PastPolicy影响
+	pastPolicy timePolicy
+}
+
+// 设置重新上报的过期时间策略，容忍范围为空时不检查
+func (o *ReplayOptions) SetPastPolicy(tolerance, action string) error {
+	policy, err := parseTimePolicy(tolerance, action)
+	if err != nil {
+		return fmt.Errorf("过期时间策略错误:%s", err.Error())
+	}
+	if policy.enabled && policy.action == policyDeadLetter && !deadLetterEnabled() {
+		return fmt.Errorf("过期时间策略为deadletter时必须配置DeadLetterPath")
+	}
+	o.pastPolicy = policy
+	return nil
+}
+
+// 解析重新上报参数，服务器格式 1-100,200，日志名称逗号分隔
+func ParseReplayOptions(job, from, to, operators, servers, records, sink string, rate int) (*ReplayOptions, error) {
+	if err := validateSinkName(job); err != nil {
+		return nil, fmt.Errorf("任务名错误:%s", err.Error())
+	}
+	options := &ReplayOptions{Job: job, Sink: sink, Rate: rate, Operators: make(map[int]bool), Records: make(map[string]bool),
+		pastPolicy: timePolicy{action: policySend}}
+	var err error
+	if options.From, err = time.Parse("2006-01-02", from); err != nil {
+		return nil, fmt.Errorf("开始日期格式(2006-01-02)错误[%s]", from)
+	}
+	if len(to) == 0 {
+		options.To = options.From
+	} else if options.To, err = time.Parse("2006-01-02", to); err != nil {
+		return nil, fmt.Errorf("结束日期格式(2006-01-02)错误[%s]", to)
+	}
+	if options.To.Before(options.From) {
+		return nil, fmt.Errorf("结束日期%s早于开始日期%s", to, from)
+	}
+	for _, item := range splitList(operators) {
+		operator, err := strconv.Atoi(item)
+		if err != nil {
+			return nil, fmt.Errorf("运营商配置错误[%s]", item)
+		}
+		options.Operators[operator] = true
+	}
+	for _, item := range splitList(servers) {
+		serverRange, err := parseServerRange(item)
+		if err != nil {
+			return nil, err
+		}
+		options.Servers = append(options.Servers, serverRange)
+	}
+	for _, item := range splitList(records) {
+		options.Records[item] = true
+	}
+	if rate < 0 {
+		return nil, fmt.Errorf("限速配置错误[%d]", rate)
+	}
+	return options, nil
+}
+
+func (o *ReplayOptions) matches(serverConfig *model.ServerConfig) bool {
+	if len(o.Operators) > 0 && !o.Operators[serverConfig.Operator] {
+		return false
+	}
+	if len(o.Servers) == 0 {
+		return true
+	}
+	for _, serverRange := range o.Servers {
+		if serverConfig.Server >= serverRange[0] && serverConfig.Server <= serverRange[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// 重新上报结果
+type ReplayResult struct {
+	Files   int   // 处理的文件数量
+	Skipped int   // 之前已经完成的文件数量
+	Lines   int64 // 处理的行数
+}
+
+// 重新上报的进度，和实时上报的断点分开记录
+type replayProgress struct {
+	Id         string
+	Job        string
+	PositionId string
+	Day        string
+	Position   int64
+	Lines      int64
+	Completed  bool
+}
+
+const replayColumns = "id,job,position_id,file_day,position,line_count,completed,updated_at"
+
+func replayProgressId(job, positionId, day string) string {
+	return job + "|" + positionId + "#" + day
+}
+
+func loadReplayProgress(ctx context.Context, id string) (*replayProgress, error) {
+	progress := &replayProgress{}
+	var completed int
+	var updatedAt string
+	err := dbPool.QueryRowContext(ctx, dbDialect.rebind("select "+replayColumns+" from replay_progress where id=?"), id).
+		Scan(&progress.Id, &progress.Job, &progress.PositionId, &progress.Day, &progress.Position, &progress.Lines, &completed, &updatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("查询重新上报进度失败%s", err.Error())
+	}
+	progress.Completed = completed != 0
+	return progress, nil
+}
+
+func saveReplayProgress(ctx context.Context, progress *replayProgress) error {
+	completed := 0
+	if progress.Completed {
+		completed = 1
+	}
+	query := dbDialect.rebind(dbDialect.upsert("replay_progress", replayColumns, "position,line_count,completed,updated_at"))
+	_, err := dbPool.ExecContext(ctx, query, progress.Id, progress.Job, progress.PositionId, progress.Day, progress.Position, progress.Lines,
+		completed, time.Now().Format(ledgerTimeLayout))
+	if err != nil {
+		return fmt.Errorf("%s保存重新上报进度失败%s", progress.Id, err.Error())
+	}
+	return nil
+}
+
+// 按照每秒行数限速
+type throttle struct {
+	rate  int
+	start time.Time
+	lines int64
+}
+
+// 处理lines行之后等待，平均速度不超过限制
+func (t *throttle) wait(ctx context.Context, lines int) error {
+	if t.rate <= 0 {
+		return nil
+	}
+	if t.start.IsZero() {
+		t.start = time.Now()
+	}
+	t.lines += int64(lines)
+	expected := time.Duration(float64(t.lines) / float64(t.rate) * float64(time.Second))
+	delay := expected - time.Since(t.start)
+	if delay <= 0 {
+		return nil
+	}
+	select {
+	case <-time.After(delay):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// 重新上报日期范围内的日志到指定输出目标，进度记录在replay_progress中，不影响实时上报的断点。
+// recordTypes为日志名称对应的日志类型(tlog,flog)
+func Replay(ctx context.Context, appConfig *model.AppConfig, serverConfigs map[string]*model.ServerConfig, recordTypes map[string]string,
+	options *ReplayOptions, process func(ctx context.Context, source *LogSource, lines []string) error) (*ReplayResult, error) {
+	if dbPool == nil {
+		panic("数据库未完成初始化")
+	}
+	if _, ok := sinks[options.Sink]; !ok {
+		return nil, fmt.Errorf("输出目标[%s]不存在", options.Sink)
+	}
+	servers := make([]*model.ServerConfig, 0, len(serverConfigs))
+	for _, serverConfig := range serverConfigs {
+		if options.matches(serverConfig) {
+			servers = append(servers, serverConfig)
+		}
+	}
+	sort.Slice(servers, func(i, j int) bool {
+		if servers[i].Operator != servers[j].Operator {
+			return servers[i].Operator < servers[j].Operator
+		}
+		return servers[i].Server < servers[j].Server
+	})
+	records := make([]string, 0, len(recordTypes))
+	for record := range recordTypes {
+		if len(options.Records) == 0 || options.Records[record] {
+			records = append(records, record)
+		}
+	}
+	sort.Strings(records)
+	if len(servers) == 0 || len(records) == 0 {
+		return nil, fmt.Errorf("没有匹配的服务器或者日志")
+	}
+
+	result := &ReplayResult{}
+	limiter := &throttle{rate: options.Rate}
+	for day := options.From; !day.After(options.To); day = day.Add(24 * time.Hour) {
+		for _, serverConfig := range servers {
+			location := serverLocation(appConfig, serverConfig)
+			for _, record := range records {
+				format := fileFormatFor(appConfig, record, recordTypes[record])
+				if ctx.Err() != nil {
+					return result, ctx.Err()
+				}
+				source := &LogSource{
+					Operator:   serverConfig.Operator,
+					Server:     serverConfig.Server,
+					Port:       serverConfig.Port,
+					RecordName: record,
+					LogType:    recordTypes[record],
+					Location:   location,
+					Sink:       options.Sink,
+					pastPolicy: &options.pastPolicy,
+				}
+				source.Path = logFilePath(appConfig.LogRootPath, serverConfig.Port, appConfig.LogRelatedPath, source.LogType, source.Operator, source.Server, record, day)
+				if err := replayFile(ctx, source, format, day, options, limiter, process, result); err != nil {
+					return result, err
+				}
+			}
+		}
+	}
+	return result, nil
+}
+
+func replayFile(ctx context.Context, source *LogSource, format *fileFormat, day time.Time, options *ReplayOptions, limiter *throttle,
+	process func(ctx context.Context, source *LogSource, lines []string) error, result *ReplayResult) error {
+	if _, err := os.Stat(source.Path); err != nil {
+		return nil
+	}
+	dayStr := day.Format("2006-01-02")
+	positionId := positionId(source.Operator, source.Server, source.RecordName, source.Sink)
+	id := replayProgressId(options.Job, positionId, dayStr)
+	progress, err := loadReplayProgress(ctx, id)
+	if err != nil {
+		return err
+	}
+	if progress == nil {
+		progress = &replayProgress{Id: id, Job: options.Job, PositionId: positionId, Day: dayStr}
+	}
+	if progress.Completed {
+		result.Skipped++
+		return nil
+	}
+	log.Println("重新上报", source.Path, "开始位置", progress.Position, "输出目标", source.Sink)
+	err = scanFile(ctx, source.Path, progress.Position, format, true, func(position int64, lines []string, offsets []int64) error {
+		batch := *source
+		batch.Offsets = offsets
+		batch.Stats = newBatchStats()
+		if err := process(ctx, &batch, lines); err != nil {
+			return err
+		}
+		progress.Position = position
+		progress.Lines += int64(len(lines))
+		result.Lines += int64(len(lines))
+		if err := saveReplayProgress(ctx, progress); err != nil {
+			return err
+		}
+		batch.flushDeadLetters()
+		return limiter.wait(ctx, len(lines))
+	})
+	if err != nil {
+		return err
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	progress.Completed = true
+	result.Files++
+	log.Println("重新上报完成", source.Path, "行数", progress.Lines)
+	return saveReplayProgress(ctx, progress)
+}
@@ -0,0 +1,140 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+	"xai.com/shushu/app/model"
+)
+
+func TestParseReplayOptions(t *testing.T) {
+	options, err := ParseReplayOptions("fix", "2021-05-02", "", "1,3", "1-10,20", "ItemRecord", "", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !options.To.Equal(options.From) || len(options.Servers) != 2 || !options.Records["ItemRecord"] {
+		t.Fatal("参数解析错误", options)
+	}
+	if !options.matches(&model.ServerConfig{Operator: 3, Server: 20}) || options.matches(&model.ServerConfig{Operator: 2, Server: 5}) ||
+		options.matches(&model.ServerConfig{Operator: 1, Server: 11}) {
+		t.Fatal("服务器匹配错误")
+	}
+	for _, args := range [][]string{
+		{"", "2021-05-02", ""},
+		{"fix", "2021/05/02", ""},
+		{"fix", "2021-05-02", "2021-05-01"},
+	} {
+		if _, err := ParseReplayOptions(args[0], args[1], args[2], "", "", "", "", 0); err == nil {
+			t.Fatal("参数错误没有返回错误", args)
+		}
+	}
+}
+
+func TestReplayPastPolicy(t *testing.T) {
+	options, _ := ParseReplayOptions("fix", "2021-05-02", "", "", "", "", "", 0)
+	if options.pastPolicy.enabled {
+		t.Fatal("重新上报默认不检查过期时间")
+	}
+	if err := options.SetPastPolicy("720h", policyClamp); err != nil || !options.pastPolicy.enabled || options.pastPolicy.action != policyClamp {
+		t.Fatal("过期时间策略错误", options.pastPolicy, err)
+	}
+	for _, args := range [][]string{{"abc", "drop"}, {"1h", "retry"}, {"1h", policyDeadLetter}} {
+		if err := options.SetPastPolicy(args[0], args[1]); err == nil {
+			t.Fatal(args, "应该校验失败")
+		}
+	}
+}
+
+func TestReplay(t *testing.T) {
+	defer useSqliteDatabase(t)()
+	sinks = map[string]*sink{defaultSinkName: {name: defaultSinkName}}
+	defer func() { sinks = nil }()
+	root := writeTestLogs(t, map[string]string{
+		"3_12_ItemRecord.2021-05-01":  "a\nb\n",
+		"3_12_ItemRecord.2021-05-02":  "c\nd\ne\n",
+		"3_12_ItemRecord.2021-05-03":  "f\n",
+		"3_12_LoginRecord.2021-05-02": "g\n",
+	})
+	defer func() { _ = os.RemoveAll(root) }()
+	appConfig := &model.AppConfig{LogRootPath: root, LogRelatedPath: "logs"}
+	serverConfigs := map[string]*model.ServerConfig{
+		"3_12": {Operator: 3, Server: 12, Port: "8001"},
+		"3_13": {Operator: 3, Server: 13, Port: "8002"},
+	}
+	recordTypes := map[string]string{"ItemRecord": "tlog", "LoginRecord": "tlog"}
+	options, err := ParseReplayOptions("fix", "2021-05-02", "2021-05-03", "", "12", "ItemRecord", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 第一次在第二个文件失败
+	var replayed []string
+	result, err := Replay(context.Background(), appConfig, serverConfigs, recordTypes, options, func(ctx context.Context, source *LogSource, lines []string) error {
+		if lines[0] == "f" {
+			return errors.New("上报失败")
+		}
+		replayed = append(replayed, lines...)
+		return nil
+	})
+	if err == nil || result.Files != 1 || result.Lines != 3 || len(replayed) != 3 {
+		t.Fatal("第一个文件应该完成", result, err, replayed)
+	}
+	// 相同任务名继续时跳过已完成的文件
+	replayed = nil
+	result, err = Replay(context.Background(), appConfig, serverConfigs, recordTypes, options, func(ctx context.Context, source *LogSource, lines []string) error {
+		if source.Sink != "" || source.Stats == nil || source.pastPolicy == nil || source.pastPolicy.enabled {
+			t.Fatal("日志来源错误", source)
+		}
+		replayed = append(replayed, lines...)
+		return nil
+	})
+	if err != nil || result.Files != 1 || result.Skipped != 1 || len(replayed) != 1 || replayed[0] != "f" {
+		t.Fatal("应该从上次进度继续", result, err, replayed)
+	}
+	// 重新上报不修改实时断点
+	position, err := LoadOne(context.Background(), 3, 12, "ItemRecord", "")
+	if err != nil || position != nil {
+		t.Fatal("重新上报不应该保存实时断点", position, err)
+	}
+	progress, err := loadReplayProgress(context.Background(), replayProgressId("fix", positionId(3, 12, "ItemRecord", ""), "2021-05-02"))
+	if err != nil || progress == nil || !progress.Completed || progress.Lines != 3 || progress.Position != 6 {
+		t.Fatal("重新上报进度错误", progress, err)
+	}
+
+	options.Sink = "unknown"
+	if _, err := Replay(context.Background(), appConfig, serverConfigs, recordTypes, options, nil); err == nil {
+		t.Fatal("不存在的输出目标应该返回错误")
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	limiter := &throttle{rate: 100}
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := limiter.wait(context.Background(), 10); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond {
+		t.Fatal("限速没有生效", elapsed)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := limiter.wait(ctx, 1000); err != context.Canceled {
+		t.Fatal("取消后应该立即返回", err)
+	}
+	if err := (&throttle{}).wait(ctx, 1000); err != nil {
+		t.Fatal("不限速时不等待", err)
+	}
+}
+
+func TestUpsert(t *testing.T) {
+	if query := dialects["mysql"].upsert("t", "id,a,b", "a,b"); query != "insert into t(id,a,b) values(?,?,?) on duplicate key update a=values(a),b=values(b)" {
+		t.Fatal(query)
+	}
+	if query := dialects["postgres"].rebind(dialects["postgres"].upsert("t", "id,a", "a")); query != "insert into t(id,a) values($1,$2) on conflict(id) do update set a=excluded.a" {
+		t.Fatal(query)
+	}
+}
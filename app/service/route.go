@@ -0,0 +1,242 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"xai.com/shushu/app/model"
+)
+
+// 上报指标分组，key为 目标名_指标
+const uploadMetrics = "upload"
+
+// 上报目标，每个目标独立维护重试状态
+type destination struct {
+	name          string
+	url           string
+	appId         string
+	token         string
+	retryTimes    int           // 最大重试次数
+	retryInterval time.Duration // 重试间隔
+	compressor    *compressor   // 上报内容压缩方式
+
+	lock        sync.Mutex
+	failures    int       // 连续失败次数
+	lastError   string    // 最后一次失败原因
+	lastSuccess time.Time // 最后一次成功时间
+}
+
+func (d *destination) String() string {
+	return d.name
+}
+
+// 记录上报结果
+func (d *destination) record(rows int, err error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	addMetric(uploadMetrics, d.name+"_requests", 1)
+	if err == nil {
+		d.failures = 0
+		d.lastError = ""
+		d.lastSuccess = time.Now()
+		addMetric(uploadMetrics, d.name+"_rows", int64(rows))
+		return
+	}
+	d.failures++
+	d.lastError = err.Error()
+	addMetric(uploadMetrics, d.name+"_failures", 1)
+}
+
+// 连续失败次数
+func (d *destination) consecutiveFailures() int {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.failures
+}
+
+// 路由规则
+type uploadRoute struct {
+	id          int
+	operators   map[int]bool // 为空匹配所有运营商
+	servers     [][2]int     // 服务器范围，为空匹配所有服务器
+	eventNames  map[string]bool
+	destination *destination
+}
+
+func (r *uploadRoute) matches(operator, server int, eventName string) bool {
+	if len(r.operators) > 0 && !r.operators[operator] {
+		return false
+	}
+	if len(r.eventNames) > 0 && !r.eventNames[eventName] {
+		return false
+	}
+	if len(r.servers) == 0 {
+		return true
+	}
+	for _, serverRange := range r.servers {
+		if server >= serverRange[0] && server <= serverRange[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// 一个输出目标的路由表
+type router struct {
+	routes             []*uploadRoute
+	defaultDestination *destination
+	cache              sync.Map
+}
+
+// 加载路由表，按照id顺序匹配，都不匹配时使用默认上报目标。
+// 非默认输出目标的上报目标名称增加输出目标前缀，避免指标冲突；路由没有配置压缩方式时使用compress
+func newRouter(sinkName, url, appId, compress, routePath string) *router {
+	prefix := ""
+	if sinkName != defaultSinkName {
+		prefix = sinkName + "/"
+	}
+	defaultCompressor, err := newCompressor(compress)
+	if err != nil {
+		panic("输出目标" + sinkName + "压缩方式配置错误:" + err.Error())
+	}
+	target := &router{}
+	if len(url) > 0 {
+		target.defaultDestination = &destination{name: prefix + "default", url: url, appId: appId, retryTimes: 5, retryInterval: 2 * time.Second, compressor: defaultCompressor}
+	}
+	loaded := make([]*uploadRoute, 0)
+	if len(routePath) > 0 {
+		routeStorage := NewStorage(reflect.TypeOf(model.UploadRoute{}))
+		routeStorage.Load(routePath)
+		for _, item := range routeStorage.GetAll() {
+			route, err := newUploadRoute(item.(*model.UploadRoute))
+			if err != nil {
+				panic("路由配置错误" + routePath + ":" + err.Error())
+			}
+			route.destination.name = prefix + route.destination.name
+			if route.destination.compressor == nil {
+				route.destination.compressor = defaultCompressor
+			}
+			loaded = append(loaded, route)
+		}
+		sort.Slice(loaded, func(i, j int) bool { return loaded[i].id < loaded[j].id })
+		log.Println("加载上报路由", routePath, "数量", len(loaded))
+	}
+	target.routes = loaded
+	// 不匹配的数据没有上报目标时无法保存断点
+	if target.defaultDestination == nil && !target.coversAll() {
+		panic("http上报必须配置HttpServerUrl,或者RoutePath中包含不限制运营商,服务器和事件名的路由")
+	}
+	return target
+}
+
+func newUploadRoute(setting *model.UploadRoute) (*uploadRoute, error) {
+	if len(setting.Url) == 0 || len(setting.AppId) == 0 {
+		return nil, fmt.Errorf("路由[%d]缺少url或者appid", setting.Id)
+	}
+	route := &uploadRoute{id: setting.Id, operators: make(map[int]bool), eventNames: make(map[string]bool)}
+	for _, item := range splitList(setting.Operator) {
+		operator, err := strconv.Atoi(item)
+		if err != nil {
+			return nil, fmt.Errorf("路由[%d]运营商配置错误[%s]", setting.Id, item)
+		}
+		route.operators[operator] = true
+	}
+	for _, item := range splitList(setting.Server) {
+		serverRange, err := parseServerRange(item)
+		if err != nil {
+			return nil, fmt.Errorf("路由[%d]%s", setting.Id, err.Error())
+		}
+		route.servers = append(route.servers, serverRange)
+	}
+	for _, item := range splitList(setting.EventName) {
+		route.eventNames[item] = true
+	}
+	retryTimes := setting.RetryTimes
+	if retryTimes <= 0 {
+		retryTimes = 5
+	}
+	retryInterval := 2 * time.Second
+	if len(setting.RetryInterval) > 0 {
+		duration, err := time.ParseDuration(setting.RetryInterval)
+		if err != nil {
+			return nil, fmt.Errorf("路由[%d]重试间隔配置错误[%s]", setting.Id, setting.RetryInterval)
+		}
+		retryInterval = duration
+	}
+	name := setting.Name
+	if len(name) == 0 {
+		name = "route" + strconv.Itoa(setting.Id)
+	}
+	route.destination = &destination{name: name, url: setting.Url, appId: setting.AppId, token: setting.Token, retryTimes: retryTimes, retryInterval: retryInterval}
+	if len(setting.Compress) > 0 {
+		c, err := newCompressor(setting.Compress)
+		if err != nil {
+			return nil, fmt.Errorf("路由[%d]%s", setting.Id, err.Error())
+		}
+		route.destination.compressor = c
+	}
+	return route, nil
+}
+
+// 服务器范围，格式 10 或者 1-100
+func parseServerRange(item string) ([2]int, error) {
+	parts := strings.SplitN(item, "-", 2)
+	from, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return [2]int{}, fmt.Errorf("服务器范围配置错误[%s]", item)
+	}
+	to := from
+	if len(parts) == 2 {
+		to, err = strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil || to < from {
+			return [2]int{}, fmt.Errorf("服务器范围配置错误[%s]", item)
+		}
+	}
+	return [2]int{from, to}, nil
+}
+
+func splitList(config string) []string {
+	result := make([]string, 0)
+	for _, item := range strings.Split(config, ",") {
+		item = strings.TrimSpace(item)
+		if len(item) > 0 {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// 所有数据都有上报目标，有默认目标或者有不限制条件的路由
+func (r *router) coversAll() bool {
+	if r.defaultDestination != nil {
+		return true
+	}
+	for _, route := range r.routes {
+		if len(route.operators) == 0 && len(route.servers) == 0 && len(route.eventNames) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// 查找上报目标，没有匹配的路由并且没有默认目标时返回nil
+func (r *router) routeFor(operator, server int, eventName string) *destination {
+	key := strconv.Itoa(operator) + "_" + strconv.Itoa(server) + "_" + eventName
+	if cache, ok := r.cache.Load(key); ok {
+		return cache.(*destination)
+	}
+	target := r.defaultDestination
+	for _, route := range r.routes {
+		if route.matches(operator, server, eventName) {
+			target = route.destination
+			break
+		}
+	}
+	r.cache.Store(key, target)
+	return target
+}
@@ -0,0 +1,132 @@
+package service
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"xai.com/shushu/app/model"
+)
+
+func TestRouteFor(t *testing.T) {
+	settings := []*model.UploadRoute{
+		{Id: 2, Operator: "1,2", Server: "1-100", Url: "http://a", AppId: "app_a"},
+		{Id: 1, Operator: "1", EventName: "charge_record", Url: "http://b", AppId: "app_b", RetryTimes: 3, RetryInterval: "1s"},
+	}
+	loaded := make([]*uploadRoute, 0)
+	for _, setting := range settings {
+		route, err := newUploadRoute(setting)
+		if err != nil {
+			t.Fatal(err)
+		}
+		loaded = append([]*uploadRoute{route}, loaded...)
+	}
+	table := &router{routes: loaded, defaultDestination: &destination{name: "default"}}
+
+	if target := table.routeFor(1, 500, "charge_record"); target.appId != "app_b" || target.retryTimes != 3 || target.retryInterval != time.Second {
+		t.Fatal("按照事件名路由错误", target)
+	}
+	if target := table.routeFor(2, 50, "item_record"); target.appId != "app_a" {
+		t.Fatal("按照服务器范围路由错误", target)
+	}
+	if target := table.routeFor(2, 101, "item_record"); target != table.defaultDestination {
+		t.Fatal("没有匹配时应该使用默认目标", target)
+	}
+}
+
+func TestRouterCoversAll(t *testing.T) {
+	partial, _ := newUploadRoute(&model.UploadRoute{Id: 1, Operator: "1", Url: "http://a", AppId: "a"})
+	all, _ := newUploadRoute(&model.UploadRoute{Id: 2, Url: "http://b", AppId: "b"})
+	if (&router{routes: []*uploadRoute{partial}}).coversAll() {
+		t.Fatal("没有默认目标和不限制条件的路由时有数据无法上报")
+	}
+	if !(&router{routes: []*uploadRoute{partial, all}}).coversAll() || !(&router{defaultDestination: &destination{}}).coversAll() {
+		t.Fatal("所有数据都有上报目标")
+	}
+	if target := (&router{routes: []*uploadRoute{partial, all}}).routeFor(2, 1, "login"); target != all.destination {
+		t.Fatal("应该使用不限制条件的路由", target)
+	}
+}
+
+func TestNewUploadRouteError(t *testing.T) {
+	for _, setting := range []*model.UploadRoute{
+		{Id: 1, Url: "http://a"},
+		{Id: 2, Url: "http://a", AppId: "a", Server: "100-1"},
+		{Id: 3, Url: "http://a", AppId: "a", Operator: "x"},
+		{Id: 4, Url: "http://a", AppId: "a", RetryInterval: "abc"},
+	} {
+		if _, err := newUploadRoute(setting); err == nil {
+			t.Fatal(setting.Id, "应该校验失败")
+		}
+	}
+}
+
+func TestUploadRows(t *testing.T) {
+	var received []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("appid") != "app_a" || r.Header.Get("Authorization") != "Bearer token_a" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		reader, err := gzip.NewReader(r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(reader)
+		_ = json.Unmarshal(body, &received)
+		_, _ = w.Write([]byte(`{"code":0}`))
+	}))
+	defer server.Close()
+	httpClient = server.Client()
+	target := &destination{name: "test", url: server.URL, appId: "app_a", token: "token_a", retryTimes: 1}
+	sent := getMetric(uploadMetrics, "test_rows")
+	err := uploadRows(context.Background(), target, []*uploadRow{testUploadRow(map[string]interface{}{"#type": "track"}, nil), testUploadRow(map[string]interface{}{"#type": "user_set"}, nil)})
+	if err != nil || len(received) != 2 {
+		t.Fatal("上报数据错误", received)
+	}
+	if getMetric(uploadMetrics, "test_rows")-sent != 2 || target.consecutiveFailures() != 0 {
+		t.Fatal("上报指标错误")
+	}
+}
+
+func TestUploadRowsFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+	httpClient = server.Client()
+	target := &destination{name: "down", url: server.URL, appId: "app_a", retryTimes: 2}
+	failures := getMetric(uploadMetrics, "down_failures")
+	if err := uploadRows(context.Background(), target, []*uploadRow{testUploadRow(map[string]interface{}{"#type": "track"}, nil)}); err == nil {
+		t.Fatal("重试全部失败时应该返回错误")
+	}
+	if target.consecutiveFailures() != 2 || getMetric(uploadMetrics, "down_failures")-failures != 2 {
+		t.Fatal("失败指标错误")
+	}
+}
+
+func TestUploadRowsDeadline(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = ioutil.ReadAll(r.Body)
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer server.Close()
+	httpClient = server.Client()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	target := &destination{name: "deadline", url: server.URL, retryTimes: 3, retryInterval: time.Hour}
+	if err := uploadRows(ctx, target, []*uploadRow{testUploadRow(map[string]interface{}{"#type": "track"}, nil)}); err == nil {
+		t.Fatal("超时后应该返回错误")
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("超时没有传递到上报请求", time.Since(start))
+	}
+}
@@ -0,0 +1,296 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"unicode/utf8"
+)
+
+// 一个字段
+type rowField struct {
+	name  string
+	value interface{}
+}
+
+// 按照名称排序的字段列表，输出json时顺序固定
+type rowFields []rowField
+
+func (f rowFields) search(name string) (int, bool) {
+	index := sort.Search(len(f), func(i int) bool { return f[i].name >= name })
+	return index, index < len(f) && f[index].name == name
+}
+
+func (f rowFields) get(name string) (interface{}, bool) {
+	if index, ok := f.search(name); ok {
+		return f[index].value, true
+	}
+	return nil, false
+}
+
+func (f *rowFields) set(name string, value interface{}) {
+	index, ok := f.search(name)
+	if ok {
+		(*f)[index].value = value
+		return
+	}
+	*f = append(*f, rowField{})
+	copy((*f)[index+1:], (*f)[index:])
+	(*f)[index] = rowField{name: name, value: value}
+}
+
+func (f *rowFields) remove(name string) {
+	if index, ok := f.search(name); ok {
+		*f = append((*f)[:index], (*f)[index+1:]...)
+	}
+}
+
+// 只保留keep返回true的字段
+func (f *rowFields) filter(keep func(name string, value interface{}) bool) {
+	kept := (*f)[:0]
+	for _, field := range *f {
+		if keep(field.name, field.value) {
+			kept = append(kept, field)
+		}
+	}
+	*f = kept
+}
+
+// 一行上报数据，#开头的系统字段和properties中的属性分开保存
+type uploadRow struct {
+	fields     rowFields
+	properties rowFields
+}
+
+func newUploadRow(fields, properties int) *uploadRow {
+	return &uploadRow{fields: make(rowFields, 0, fields), properties: make(rowFields, 0, properties)}
+}
+
+// 按照名称获取已经解析的字段，系统字段优先
+func (r *uploadRow) value(name string) (interface{}, bool) {
+	if value, ok := r.fields.get(name); ok {
+		return value, true
+	}
+	return r.properties.get(name)
+}
+
+// 按照数数格式输出json，系统字段在前，properties为空时不输出
+func (r *uploadRow) appendJSON(buf []byte) []byte {
+	buf = append(buf, '{')
+	for i, field := range r.fields {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf = appendJSONString(buf, field.name)
+		buf = append(buf, ':')
+		buf = appendJSONValue(buf, field.value)
+	}
+	if len(r.properties) > 0 {
+		if len(r.fields) > 0 {
+			buf = append(buf, ',')
+		}
+		buf = append(buf, `"properties":{`...)
+		for i, field := range r.properties {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			buf = appendJSONString(buf, field.name)
+			buf = append(buf, ':')
+			buf = appendJSONValue(buf, field.value)
+		}
+		buf = append(buf, '}')
+	}
+	return append(buf, '}')
+}
+
+func (r *uploadRow) String() string {
+	return string(r.appendJSON(nil))
+}
+
+// 按照encoding/json的格式输出常用类型，其他类型使用json.Marshal
+func appendJSONValue(buf []byte, value interface{}) []byte {
+	switch v := value.(type) {
+	case nil:
+		return append(buf, "null"...)
+	case string:
+		return appendJSONString(buf, v)
+	case bool:
+		return strconv.AppendBool(buf, v)
+	case int:
+		return strconv.AppendInt(buf, int64(v), 10)
+	case int64:
+		return strconv.AppendInt(buf, v, 10)
+	case float64:
+		return appendJSONFloat(buf, v)
+	case []string:
+		buf = append(buf, '[')
+		for i, item := range v {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			buf = appendJSONString(buf, item)
+		}
+		return append(buf, ']')
+	case []int:
+		buf = append(buf, '[')
+		for i, item := range v {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			buf = strconv.AppendInt(buf, int64(item), 10)
+		}
+		return append(buf, ']')
+	case []float64:
+		buf = append(buf, '[')
+		for i, item := range v {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			buf = appendJSONFloat(buf, item)
+		}
+		return append(buf, ']')
+	}
+	// json类型字段等，map按照key排序输出
+	content, err := json.Marshal(value)
+	if err != nil {
+		log.Println("序列化字段失败,使用null", value, err)
+		return append(buf, "null"...)
+	}
+	return append(buf, content...)
+}
+
+// 与encoding/json相同，大数和小数使用科学计数法，NaN和Inf无法表示为null
+func appendJSONFloat(buf []byte, value float64) []byte {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return append(buf, "null"...)
+	}
+	format := byte('f')
+	if abs := math.Abs(value); abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+		format = 'e'
+	}
+	buf = strconv.AppendFloat(buf, value, format, -1, 64)
+	if format == 'e' {
+		// 1e-07 转换为 1e-7
+		n := len(buf)
+		if n >= 4 && buf[n-4] == 'e' && buf[n-3] == '-' && buf[n-2] == '0' {
+			buf[n-2] = buf[n-1]
+			buf = buf[:n-1]
+		}
+	}
+	return buf
+}
+
+const hexDigits = "0123456789abcdef"
+
+// 转义json字符串，无效的utf-8替换为�
+func appendJSONString(buf []byte, str string) []byte {
+	buf = append(buf, '"')
+	start := 0
+	for i := 0; i < len(str); {
+		if c := str[i]; c < utf8.RuneSelf {
+			if c >= 0x20 && c != '"' && c != '\\' {
+				i++
+				continue
+			}
+			buf = append(buf, str[start:i]...)
+			switch c {
+			case '"', '\\':
+				buf = append(buf, '\\', c)
+			case '\n':
+				buf = append(buf, '\\', 'n')
+			case '\r':
+				buf = append(buf, '\\', 'r')
+			case '\t':
+				buf = append(buf, '\\', 't')
+			default:
+				buf = append(buf, '\\', 'u', '0', '0', hexDigits[c>>4], hexDigits[c&0xF])
+			}
+			i++
+			start = i
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(str[i:])
+		if r == utf8.RuneError && size == 1 {
+			buf = append(buf, str[start:i]...)
+			buf = append(buf, "\ufffd"...)
+			i += size
+			start = i
+			continue
+		}
+		// 与encoding/json相同，转义js中的行分隔符
+		if r == '\u2028' || r == '\u2029' {
+			buf = append(buf, str[start:i]...)
+			buf = append(buf, '\\', 'u', '2', '0', '2', hexDigits[r&0xF])
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	buf = append(buf, str[start:]...)
+	return append(buf, '"')
+}
+
+// 复用的压缩缓冲区
+var bodyBuffers = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+
+// 压缩后的上报内容，上报结束后调用release归还缓冲区
+type uploadBody struct {
+	buffer      *bytes.Buffer
+	size        int    // 压缩前大小
+	compression string // 压缩方式，作为compress请求头
+}
+
+func (b *uploadBody) Bytes() []byte {
+	return b.buffer.Bytes()
+}
+
+func (b *uploadBody) release() {
+	if b.buffer != nil {
+		bodyBuffers.Put(b.buffer)
+		b.buffer = nil
+	}
+}
+
+// 逐行输出json数组并写入压缩writer，不生成完整的json
+func encodeRows(rows []*uploadRow, c *compressor) (*uploadBody, error) {
+	c = compressorOrDefault(c)
+	buffer := bodyBuffers.Get().(*bytes.Buffer)
+	buffer.Reset()
+	writer := c.writer(buffer)
+	defer c.release(writer)
+	body := &uploadBody{buffer: buffer, compression: c.name}
+	scratch := make([]byte, 0, 4096)
+	scratch = append(scratch, '[')
+	for i, row := range rows {
+		if i > 0 {
+			scratch = append(scratch, ',')
+		}
+		scratch = row.appendJSON(scratch)
+		if i == len(rows)-1 {
+			scratch = append(scratch, ']')
+		}
+		if _, err := writer.Write(scratch); err != nil {
+			body.release()
+			return nil, err
+		}
+		body.size += len(scratch)
+		scratch = scratch[:0]
+	}
+	if len(rows) == 0 {
+		if _, err := writer.Write(append(scratch, ']')); err != nil {
+			body.release()
+			return nil, err
+		}
+		body.size += len(scratch) + 1
+	}
+	if err := writer.Close(); err != nil {
+		body.release()
+		return nil, err
+	}
+	return body, nil
+}
@@ -0,0 +1,110 @@
+package service
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// 测试用的上报数据
+func testUploadRow(fields map[string]interface{}, properties map[string]interface{}) *uploadRow {
+	row := newUploadRow(len(fields), len(properties))
+	for name, value := range fields {
+		row.fields.set(name, value)
+	}
+	for name, value := range properties {
+		row.properties.set(name, value)
+	}
+	return row
+}
+
+func TestUploadRowOrder(t *testing.T) {
+	row := testUploadRow(map[string]interface{}{"#type": "track", "#account_id": "acc", "#time": "2021-05-02 00:00:00.000"},
+		map[string]interface{}{"gold": int64(10), "b": true, "a": 1.5, "items": []int{1, 2}, "tags": []string{"x", "y"}})
+	expected := `{"#account_id":"acc","#time":"2021-05-02 00:00:00.000","#type":"track","properties":{"a":1.5,"b":true,"gold":10,"items":[1,2],"tags":["x","y"]}}`
+	for i := 0; i < 10; i++ {
+		if json := row.String(); json != expected {
+			t.Fatal("输出顺序错误", json)
+		}
+	}
+	row.fields.remove("#time")
+	row.properties.filter(func(name string, value interface{}) bool { return name == "gold" })
+	if json := row.String(); json != `{"#account_id":"acc","#type":"track","properties":{"gold":10}}` {
+		t.Fatal("删除字段错误", json)
+	}
+	if value, ok := row.value("gold"); !ok || value != int64(10) {
+		t.Fatal("获取属性错误", value)
+	}
+}
+
+// 与encoding/json输出一致
+func TestAppendJSONValue(t *testing.T) {
+	values := []interface{}{
+		"a\"b\\c\n\r\t\x01<>&中文 ", "bad\xffutf8", 0.0, 1.0, -2.5, 1e21, 1e-7, 123456789.125, 8.0, math.MaxInt64,
+		int64(-1), []float64{0.1, 1e-9}, map[string]interface{}{"b": 1, "a": []interface{}{"x", nil}}, nil, false,
+	}
+	for _, value := range values {
+		expected, _ := json.Marshal(value)
+		if actual := appendJSONValue(nil, value); string(actual) != string(expected) && !bytes.Equal(unescapeHTML(expected), actual) {
+			t.Fatal("序列化结果不一致", string(actual), string(expected))
+		}
+	}
+	if actual := string(appendJSONValue(nil, math.NaN())); actual != "null" {
+		t.Fatal("NaN应该输出null", actual)
+	}
+}
+
+// encoding/json默认转义<>&，数数不需要
+func unescapeHTML(content []byte) []byte {
+	content = bytes.Replace(content, []byte(`\u003c`), []byte("<"), -1)
+	content = bytes.Replace(content, []byte(`\u003e`), []byte(">"), -1)
+	return bytes.Replace(content, []byte(`\u0026`), []byte("&"), -1)
+}
+
+func TestEncodeRows(t *testing.T) {
+	rows := []*uploadRow{
+		testUploadRow(map[string]interface{}{"#type": "track", "#event_name": "login"}, map[string]interface{}{"level": int64(12)}),
+		testUploadRow(map[string]interface{}{"#type": "user_del"}, nil),
+	}
+	for _, input := range [][]*uploadRow{rows, {}} {
+		body, err := encodeRows(input, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader, err := gzip.NewReader(bytes.NewReader(body.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := ioutil.ReadAll(reader)
+		body.release()
+		var decoded []map[string]interface{}
+		if err := json.Unmarshal(content, &decoded); err != nil || len(decoded) != len(input) || body.size != len(content) {
+			t.Fatal("压缩内容错误", string(content), err)
+		}
+		if len(input) > 0 && !reflect.DeepEqual(decoded[0]["properties"], map[string]interface{}{"level": 12.0}) {
+			t.Fatal("属性错误", decoded[0])
+		}
+	}
+}
+
+func BenchmarkEncodeRows(b *testing.B) {
+	rows := make([]*uploadRow, 2000)
+	for i := range rows {
+		rows[i] = testUploadRow(map[string]interface{}{"#type": "track", "#event_name": "item_record", "#account_id": "robot-2-61.1_1",
+			"#time": "2021-05-02 12:00:00.000", "#zone_offset": 8.0},
+			map[string]interface{}{"item_id": int64(i), "count": int64(10), "reason": "任务奖励", "server": 12, "items": []int{1, 2, 3}})
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		body, err := encodeRows(rows, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		body.release()
+	}
+}
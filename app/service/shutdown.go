@@ -0,0 +1,119 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 正在扫描的输出目标
+var scanWait sync.WaitGroup
+
+// 扫描调度，done在调度协程结束后关闭，abort取消上报使用的context，正在进行的上报和重试等待立即结束
+var scheduler struct {
+	sync.Mutex
+	done  <-chan struct{}
+	abort context.CancelFunc
+}
+
+// 放弃上报后等待扫描结束的时间
+const abortWait = 5 * time.Second
+
+// 停止所有扫描任务，等待正在处理的批次上报完成并保存断点。调用前需要取消StartScanLog的ctx。
+// 超过drainTimeout后放弃正在进行的上报，返回false表示有数据没有上报确认
+func Shutdown(drainTimeout time.Duration) bool {
+	tasks.Range(func(key, value interface{}) bool {
+		value.(*logTask).Close()
+		return true
+	})
+	scheduler.Lock()
+	done, abort := scheduler.done, scheduler.abort
+	scheduler.Unlock()
+	deadline := time.Now().Add(drainTimeout)
+	// 调度协程结束后不会再开始新一轮扫描，scanWait不会再增加
+	if done != nil {
+		select {
+		case <-done:
+		case <-time.After(drainTimeout):
+			log.Println("等待扫描调度结束超时")
+		}
+	}
+	log.Println("等待正在处理的日志上报完成,最长等待", drainTimeout)
+	clean := true
+	if !waitTimeout(&scanWait, time.Until(deadline)) {
+		clean = false
+		log.Println("等待上报超时,放弃正在进行的上报")
+		if abort != nil {
+			abort()
+		}
+		if !waitTimeout(&scanWait, abortWait) {
+			log.Println("放弃上报后扫描任务仍然没有结束")
+		}
+	}
+	tasks.Range(func(key, value interface{}) bool {
+		task := value.(*logTask)
+		if task.busy() {
+			log.Println(key, "扫描没有结束,有没有上报确认的数据,断点停留在上次保存的位置")
+			clean = false
+		}
+		return true
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), abortWait)
+	defer cancel()
+	if err := flushCheckpoints(ctx); err != nil {
+		log.Println("保存断点失败", err)
+		clean = false
+	}
+	return clean
+}
+
+// 扫描协程没有结束，断点可能正在被修改
+func (t *logTask) busy() bool {
+	return atomic.LoadInt32(&t.scanning) > 0 || atomic.LoadInt32(&t.inflight) > 0
+}
+
+// 在一个事务中保存延迟写入的断点和所有扫描已经结束的任务的断点
+func flushCheckpoints(ctx context.Context) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("%v", e)
+		}
+	}()
+	positions, ledgers := checkpoints.take()
+	tasks.Range(func(key, value interface{}) bool {
+		task := value.(*logTask)
+		if task.busy() {
+			return true
+		}
+		positions[task.logPosition.Id] = *task.logPosition
+		if task.ledger != nil {
+			ledgers[task.ledger.Id] = task.ledger.clone()
+		}
+		if task.closing != nil && task.closing.entry != nil {
+			ledgers[task.closing.entry.Id] = task.closing.entry.clone()
+		}
+		return true
+	})
+	if len(positions) == 0 && len(ledgers) == 0 {
+		return nil
+	}
+	return writeCheckpoints(ctx, positions, ledgers)
+}
+
+// 等待结束，超时返回false
+func waitTimeout(wait *sync.WaitGroup, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wait.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
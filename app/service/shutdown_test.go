@@ -0,0 +1,107 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLogTaskClose(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	task := &logTask{ctx: ctx, cancel: cancel}
+	task.Close()
+	task.Close()
+	if !task.Closed() || !task.Closed() {
+		t.Fatal("任务应该保持停止状态")
+	}
+}
+
+func TestShutdownAbortsUploads(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	httpClient = server.Client()
+	uploadCtx, abort := context.WithCancel(context.Background())
+	scheduler.abort = abort
+	defer func() {
+		tasks = sync.Map{}
+		scheduler.abort = nil
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	task := &logTask{logPosition: &logPosition{Id: "1_1_test"}, ctx: ctx, cancel: cancel}
+	tasks.Store(task.logPosition.Id, task)
+	target := &destination{name: "slow", url: server.URL, retryTimes: 100, retryInterval: time.Hour}
+	var uploadErr error
+	scanWait.Add(1)
+	atomic.AddInt32(&task.inflight, 1)
+	go func() {
+		defer scanWait.Done()
+		defer atomic.AddInt32(&task.inflight, -1)
+		uploadErr = uploadRows(uploadCtx, target, []*uploadRow{testUploadRow(map[string]interface{}{"#type": "track"}, nil)})
+		// 放弃上报时不保存断点
+		if uploadErr == nil {
+			task.logPosition.Position = 100
+		}
+	}()
+
+	start := time.Now()
+	if Shutdown(50 * time.Millisecond) {
+		t.Fatal("放弃上报后应该返回false")
+	}
+	if time.Since(start) > abortWait {
+		t.Fatal("放弃上报后应该立即结束")
+	}
+	if uploadErr == nil || task.logPosition.Position != 0 {
+		t.Fatal("放弃上报时应该返回错误并且不保存断点", uploadErr)
+	}
+	if !task.Closed() {
+		t.Fatal("任务应该已经停止")
+	}
+}
+
+func TestShutdownWithoutTasks(t *testing.T) {
+	if !Shutdown(time.Second) {
+		t.Fatal("没有任务时应该正常停止")
+	}
+}
+
+func TestShutdownWaitsForScheduler(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := StartScanLog(ctx, context.Background(), 5*time.Millisecond, nil)
+	defer func() { scheduler.done, scheduler.abort = nil, nil }()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	if !Shutdown(time.Second) {
+		t.Fatal("没有任务时应该正常停止")
+	}
+	select {
+	case <-done:
+	default:
+		t.Fatal("Shutdown应该等待调度协程结束")
+	}
+}
+
+func TestFlushCheckpointsSkipsScanningTask(t *testing.T) {
+	defer func() { tasks = sync.Map{} }()
+	ctx, cancel := context.WithCancel(context.Background())
+	task := &logTask{logPosition: &logPosition{Id: "1_1_scanning"}, ctx: ctx, cancel: cancel}
+	tasks.Store(task.logPosition.Id, task)
+	task.Close()
+	// 放弃上报后扫描协程还没有结束，不能读取正在修改的断点
+	atomic.StoreInt32(&task.scanning, 1)
+	if err := flushCheckpoints(context.Background()); err != nil {
+		t.Fatal("正在扫描的任务不能保存断点", err)
+	}
+	atomic.StoreInt32(&task.scanning, 0)
+	// 停止后开始的扫描不修改断点
+	scanOneTask(context.Background(), task, nil)
+	if task.busy() {
+		t.Fatal("扫描结束后应该可以保存断点")
+	}
+}
@@ -0,0 +1,100 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+	"xai.com/shushu/app/model"
+)
+
+// 默认输出目标名称，使用application.properties中的配置
+const defaultSinkName = ""
+
+// 输出目标，每个目标有独立的断点，互不阻塞
+type sink struct {
+	name       string
+	startDay   string // 新注册任务的开始日期
+	processors []func(context.Context, *sink, *LogSource, []string, []*model.EventConfig) error
+	router     *router // http上报路由
+}
+
+func (s *sink) String() string {
+	if s.name == defaultSinkName {
+		return "default"
+	}
+	return s.name
+}
+
+var sinks map[string]*sink
+
+func newSink(name, pushType, startDay, url, appId, compress, routePath string) *sink {
+	target := &sink{name: name, startDay: startDay}
+	if strings.Contains(pushType, "console") {
+		target.processors = append(target.processors, consoleProcess)
+	}
+	if strings.Contains(pushType, "http") {
+		target.processors = append(target.processors, httpProcess)
+		target.router = newRouter(name, url, appId, compress, routePath)
+	}
+	if len(target.processors) == 0 {
+		panic("输出目标" + target.String() + "没有配置输出类型(console,http)")
+	}
+	return target
+}
+
+// 初始化默认输出目标和Excel配置的其他输出目标
+func initSinks(config *model.AppConfig) {
+	loaded := make(map[string]*sink)
+	loaded[defaultSinkName] = newSink(defaultSinkName, config.PushType, config.StartDay, config.HttpServerUrl, config.HttpAppId, config.HttpCompress, config.RoutePath)
+	if len(config.SinkPath) > 0 {
+		sinkStorage := NewStorage(reflect.TypeOf(model.SinkSetting{}))
+		sinkStorage.Load(config.SinkPath)
+		for _, item := range sinkStorage.GetAll() {
+			setting := item.(*model.SinkSetting)
+			if err := validateSinkName(setting.Name); err != nil {
+				panic("输出目标配置错误" + config.SinkPath + ":" + err.Error())
+			}
+			if _, ok := loaded[setting.Name]; ok {
+				panic("输出目标配置错误" + config.SinkPath + ":名称重复" + setting.Name)
+			}
+			startDay := setting.StartDay
+			if len(startDay) == 0 {
+				startDay = config.StartDay
+			}
+			compress := setting.HttpCompress
+			if len(compress) == 0 {
+				compress = config.HttpCompress
+			}
+			loaded[setting.Name] = newSink(setting.Name, setting.PushType, startDay, setting.HttpServerUrl, setting.HttpAppId, compress, setting.RoutePath)
+		}
+		log.Println("加载输出目标", config.SinkPath, "数量", len(loaded)-1)
+	}
+	sinks = loaded
+}
+
+// 输出目标名称作为断点id的后缀
+func validateSinkName(name string) error {
+	if len(name) == 0 {
+		return errors.New("输出目标名称不能为空")
+	}
+	for _, c := range name {
+		if !(c == '_' || c == '-' || (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')) {
+			return fmt.Errorf("输出目标名称[%s]只能包含字母,数字,_和-", name)
+		}
+	}
+	return nil
+}
+
+// 所有输出目标名称，默认目标排在第一位
+func SinkNames() []string {
+	names := make([]string, 0, len(sinks))
+	for name := range sinks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,127 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+	"xai.com/shushu/app/model"
+)
+
+func TestPositionId(t *testing.T) {
+	if id := positionId(1, 2, "charge_record", defaultSinkName); id != "1_2_charge_record" {
+		t.Fatal("默认输出目标断点id应该保持不变", id)
+	}
+	if id := positionId(1, 2, "charge_record", "backup"); id != "1_2_charge_record@backup" {
+		t.Fatal("输出目标断点id错误", id)
+	}
+}
+
+func TestValidateSinkName(t *testing.T) {
+	for _, name := range []string{"backup", "new_project", "v-2"} {
+		if err := validateSinkName(name); err != nil {
+			t.Fatal(name, err)
+		}
+	}
+	for _, name := range []string{"", "a@b", "a b", "中文"} {
+		if err := validateSinkName(name); err == nil {
+			t.Fatal(name, "应该校验失败")
+		}
+	}
+}
+
+func TestProcessBySink(t *testing.T) {
+	var called []string
+	record := func(name string, err error) func(context.Context, *sink, *LogSource, []string, []*model.EventConfig) error {
+		return func(ctx context.Context, target *sink, source *LogSource, lines []string, eventConfigs []*model.EventConfig) error {
+			called = append(called, name)
+			return err
+		}
+	}
+	sinks = map[string]*sink{
+		defaultSinkName: {processors: []func(context.Context, *sink, *LogSource, []string, []*model.EventConfig) error{record("default", nil)}},
+		"backup":        {name: "backup", processors: []func(context.Context, *sink, *LogSource, []string, []*model.EventConfig) error{record("backup", errors.New("down")), record("after", nil)}},
+	}
+	defer func() { sinks = nil }()
+
+	if names := SinkNames(); len(names) != 2 || names[0] != defaultSinkName || names[1] != "backup" {
+		t.Fatal("输出目标名称错误", names)
+	}
+	if err := Process(context.Background(), &LogSource{}, []string{"a"}, nil); err != nil || len(called) != 1 || called[0] != "default" {
+		t.Fatal("默认输出目标处理错误", called, err)
+	}
+	called = nil
+	if err := Process(context.Background(), &LogSource{Sink: "backup"}, []string{"a"}, nil); err == nil || len(called) != 1 {
+		t.Fatal("处理失败时应该返回错误并停止后续处理", called, err)
+	}
+	if err := Process(context.Background(), &LogSource{Sink: "unknown"}, []string{"a"}, nil); err == nil {
+		t.Fatal("不存在的输出目标应该返回错误")
+	}
+}
+
+// 账号和毫秒时间两列的事件配置
+func testEventConfig(name string) *model.EventConfig {
+	fields := map[string]*model.Field{
+		"#account_id": {Index: 1, DataType: "string"},
+		"#time":       {Index: 2, DataType: "date"},
+	}
+	for _, field := range fields {
+		_ = compileFieldType(field, nil)
+	}
+	return &model.EventConfig{Name: name, RecordName: "LoginRecord", UploadType: model.UploadTrack, Fields: fields}
+}
+
+// 上报测试服务，failures次之后返回成功
+func testUploadServer(requests *int32, failures int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(requests, 1) <= failures {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte(`{"code":0}`))
+	}))
+}
+
+func TestHttpProcessPartialDelivery(t *testing.T) {
+	var loginRequests, logoutRequests int32
+	login := testUploadServer(&loginRequests, 0)
+	defer login.Close()
+	logout := testUploadServer(&logoutRequests, 1)
+	defer logout.Close()
+	httpClient = login.Client()
+
+	route, _ := newUploadRoute(&model.UploadRoute{Id: 1, EventName: "login", Url: login.URL, AppId: "a", RetryTimes: 1})
+	target := &sink{name: "partial", router: &router{routes: []*uploadRoute{route},
+		defaultDestination: &destination{name: "partial_logout", url: logout.URL, appId: "b", retryTimes: 1}}}
+	eventConfigs := []*model.EventConfig{testEventConfig("login"), testEventConfig("logout")}
+	eventTime := strconv.FormatInt(time.Now().Add(-time.Hour).UnixNano()/int64(time.Millisecond), 10)
+	lines := []string{"acc_1\t" + eventTime, "acc_2\t" + eventTime}
+	source := &LogSource{Path: "1_1_LoginRecord.2021-05-02", Offsets: []int64{0, 20}, Stats: newBatchStats()}
+
+	if err := httpProcess(context.Background(), target, source, lines, eventConfigs); err == nil {
+		t.Fatal("第二个目标失败时应该返回错误")
+	}
+	// 重新处理同一批，已经成功的目标不再上报
+	if err := httpProcess(context.Background(), target, source, lines, eventConfigs); err != nil {
+		t.Fatal(err)
+	}
+	if loginRequests != 1 || logoutRequests != 2 {
+		t.Fatal("部分成功的批次重复上报", loginRequests, logoutRequests)
+	}
+	// 整批成功后新的批次正常上报
+	source.Offsets = []int64{40, 60}
+	source.Stats = newBatchStats()
+	if err := httpProcess(context.Background(), target, source, lines, eventConfigs); err != nil || loginRequests != 2 || logoutRequests != 3 {
+		t.Fatal("新的批次应该上报到所有目标", loginRequests, logoutRequests, err)
+	}
+	if source.Stats.Acked != 2 || source.Stats.Sent["login"] != 2 || source.Stats.Sent["logout"] != 2 {
+		t.Fatal("上报成功的行统计错误", source.Stats)
+	}
+	if _, ok := deliveredOffset(source, route.destination); ok {
+		t.Fatal("整批成功后应该清除部分成功的进度")
+	}
+}
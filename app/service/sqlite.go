@@ -0,0 +1,9 @@
+//go:build cgo
+// +build cgo
+
+package service
+
+import _ "github.com/mattn/go-sqlite3"
+
+// sqlite驱动需要cgo
+const sqliteSupported = true
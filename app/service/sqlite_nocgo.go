@@ -0,0 +1,7 @@
+//go:build !cgo
+// +build !cgo
+
+package service
+
+// 没有开启cgo编译时sqlite驱动不可用，配置sqlite时启动失败
+const sqliteSupported = false
@@ -104,7 +104,7 @@ func TestJsonUnDecInterface(t *testing.T) {
 }
 
 func TestStruct(t *testing.T) {
-	field := model.Field{1, "a"}
+	field := model.Field{Index: 1, DataType: "a"}
 	mm := make(map[string]model.Field)
 	mm["abc"] = field
 
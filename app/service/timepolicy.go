@@ -0,0 +1,109 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"time"
+	"xai.com/shushu/app/model"
+)
+
+// 事件时间超出容忍范围的处理策略
+const (
+	policyDrop       = "drop"       // 忽视当前行
+	policyClamp      = "clamp"      // 时间修改为当前时间
+	policySend       = "send"       // 照常上报
+	policyDeadLetter = "deadletter" // 写入死信文件
+)
+
+// 时间策略指标分组，key为 future_drop,past_send 等
+const timePolicyMetrics = "time_policy"
+
+type timePolicy struct {
+	enabled   bool          // 是否检查
+	tolerance time.Duration // 容忍范围
+	action    string        // 超出范围的处理策略
+}
+
+var (
+	futurePolicy = timePolicy{enabled: true, action: policyDrop}
+	pastPolicy   = timePolicy{action: policySend}
+)
+
+// 初始化未来时间和过期时间策略
+func initTimePolicy(config *model.AppConfig) {
+	future, err := parseTimePolicy(config.FutureTolerance, config.FuturePolicy)
+	if err != nil {
+		panic("FutureTolerance,FuturePolicy配置错误:" + err.Error())
+	}
+	// 未来时间默认检查
+	future.enabled = true
+	past, err := parseTimePolicy(config.PastTolerance, config.PastPolicy)
+	if err != nil {
+		panic("PastTolerance,PastPolicy配置错误:" + err.Error())
+	}
+	if (future.action == policyDeadLetter || past.action == policyDeadLetter) && !deadLetterEnabled() {
+		panic("时间策略为deadletter时必须配置DeadLetterPath")
+	}
+	futurePolicy = future
+	pastPolicy = past
+}
+
+// 容忍范围为空时不检查，策略为空时为drop
+func parseTimePolicy(tolerance, action string) (timePolicy, error) {
+	policy := timePolicy{action: policyDrop}
+	if len(tolerance) > 0 {
+		duration, err := time.ParseDuration(tolerance)
+		if err != nil {
+			return policy, err
+		}
+		if duration < 0 {
+			return policy, fmt.Errorf("容忍范围不能小于0[%s]", tolerance)
+		}
+		policy.enabled = true
+		policy.tolerance = duration
+	}
+	if len(action) > 0 {
+		policy.action = action
+	}
+	switch policy.action {
+	case policyDrop, policyClamp, policySend, policyDeadLetter:
+	default:
+		return policy, fmt.Errorf("不支持的处理策略[%s]", policy.action)
+	}
+	return policy, nil
+}
+
+// 检查事件时间，返回处理后的时间和是否保留当前行
+func checkEventTime(eventConfig *model.EventConfig, source *LogSource, row *logRow, curTime, now time.Time) (time.Time, bool) {
+	var policy timePolicy
+	var window string
+	past := pastPolicy
+	if source != nil && source.pastPolicy != nil {
+		past = *source.pastPolicy
+	}
+	if futurePolicy.enabled && curTime.After(now.Add(futurePolicy.tolerance)) {
+		policy, window = futurePolicy, "future"
+	} else if past.enabled && curTime.Before(now.Add(-past.tolerance)) {
+		policy, window = past, "past"
+	} else {
+		return curTime, true
+	}
+	addMetric(timePolicyMetrics, window+"_"+policy.action, 1)
+	switch policy.action {
+	case policyClamp:
+		return now.In(curTime.Location()), true
+	case policySend:
+		return curTime, true
+	case policyDeadLetter:
+		record := &deadLetterRecord{Reason: window + "_time", Event: eventConfig.Identity(), Offset: row.offset, Line: row.String()}
+		if source == nil {
+			writeDeadLetter(record)
+			return curTime, false
+		}
+		record.File = source.Path
+		source.deferDeadLetter(row, record)
+		return curTime, false
+	}
+	log.Println(eventConfig.Name, "事件时间超出范围,忽视当前行", window, curTime, ">>", row.String())
+	return curTime, false
+}
@@ -0,0 +1,83 @@
+package service
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"xai.com/shushu/app/model"
+)
+
+func TestParseTimePolicy(t *testing.T) {
+	policy, err := parseTimePolicy("", "")
+	if err != nil || policy.enabled || policy.action != policyDrop {
+		t.Fatal("默认策略错误", policy, err)
+	}
+	policy, err = parseTimePolicy("10s", "clamp")
+	if err != nil || !policy.enabled || policy.tolerance != 10*time.Second {
+		t.Fatal("策略解析错误", policy, err)
+	}
+	for _, c := range [][]string{{"abc", "drop"}, {"-1s", "drop"}, {"1s", "retry"}} {
+		if _, err = parseTimePolicy(c[0], c[1]); err == nil {
+			t.Fatal(c, "应该解析失败")
+		}
+	}
+}
+
+func TestCheckEventTime(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deadletter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	path := filepath.Join(dir, "deadletter.log")
+	initDeadLetter(path)
+	defer initDeadLetter("")
+	initTimePolicy(&model.AppConfig{FutureTolerance: "5s", FuturePolicy: policyClamp, PastTolerance: "24h", PastPolicy: policyDeadLetter})
+	defer initTimePolicy(&model.AppConfig{})
+
+	eventConfig := &model.EventConfig{Name: "item_record", RecordName: "ItemRecord", UploadType: model.UploadTrack}
+	row := decodeLines("tsv", []string{"a\tb"}, []int64{64})[0]
+	now := time.Date(2021, 5, 2, 12, 0, 0, 0, time.UTC)
+
+	if curTime, keep := checkEventTime(eventConfig, nil, row, now.Add(3*time.Second), now); !keep || curTime != now.Add(3*time.Second) {
+		t.Fatal("容忍范围内的时间应该保留", curTime)
+	}
+	if curTime, keep := checkEventTime(eventConfig, nil, row, now.Add(time.Minute), now); !keep || curTime != now {
+		t.Fatal("超出范围的时间应该修改为当前时间", curTime)
+	}
+	source := &LogSource{Path: "3_12_ItemRecord.2021-04-01"}
+	if _, keep := checkEventTime(eventConfig, source, row, now.Add(-48*time.Hour), now); keep {
+		t.Fatal("过期时间应该写入死信")
+	}
+	// 同一行多个事件配置只记录一次
+	if _, keep := checkEventTime(&model.EventConfig{Name: "item_cost", RecordName: "ItemRecord", UploadType: model.UploadTrack}, source, row, now.Add(-48*time.Hour), now); keep {
+		t.Fatal("过期时间应该写入死信")
+	}
+	// 批次处理失败重新处理时，之前的死信不写入
+	failed := &LogSource{Path: "3_12_ItemRecord.2021-04-01"}
+	checkEventTime(eventConfig, failed, row, now.Add(-48*time.Hour), now)
+	if content, _ := ioutil.ReadFile(path); len(content) != 0 {
+		t.Fatal("保存断点之前不能写入死信", string(content))
+	}
+	source.flushDeadLetters()
+	// 日志来源单独配置的过期时间策略优先
+	if _, keep := checkEventTime(eventConfig, &LogSource{pastPolicy: &timePolicy{action: policySend}}, row, now.Add(-48*time.Hour), now); !keep {
+		t.Fatal("不检查过期时间时应该保留")
+	}
+	initDeadLetter(path)
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := &deadLetterRecord{}
+	if err = json.Unmarshal([]byte(strings.TrimSpace(string(content))), record); err != nil {
+		t.Fatal(err)
+	}
+	if record.Reason != "past_time" || record.Offset != 64 || record.File != "3_12_ItemRecord.2021-04-01" {
+		t.Fatal("死信内容错误", record)
+	}
+}
@@ -0,0 +1,80 @@
+package service
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"xai.com/shushu/app/model"
+)
+
+var locationCache sync.Map
+
+// 加载时区，为空时使用本机时区
+func loadLocation(name string) (*time.Location, error) {
+	name = strings.TrimSpace(name)
+	if len(name) == 0 {
+		return time.Local, nil
+	}
+	if location, ok := locationCache.Load(name); ok {
+		return location.(*time.Location), nil
+	}
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	locationCache.Store(name, location)
+	return location, nil
+}
+
+// 计算服务器时区，优先级: serverlist配置 > 运营商配置 > 默认配置 > 本机时区
+func serverLocation(appConfig *model.AppConfig, serverConfig *model.ServerConfig) *time.Location {
+	name := serverConfig.Timezone
+	if len(name) == 0 {
+		name = operatorTimezones(appConfig.OperatorTimezone)[serverConfig.Operator]
+	}
+	if len(name) == 0 {
+		name = appConfig.DefaultTimezone
+	}
+	location, err := loadLocation(name)
+	if err != nil {
+		panic("服务器" + strconv.Itoa(serverConfig.Operator) + "_" + strconv.Itoa(serverConfig.Server) + "时区配置错误:" + err.Error())
+	}
+	return location
+}
+
+// 解析运营商时区配置，格式 1=Asia/Shanghai;2=America/Los_Angeles
+func operatorTimezones(config string) map[int]string {
+	result := make(map[int]string)
+	for _, item := range strings.Split(config, ";") {
+		index := strings.Index(item, "=")
+		if index <= 0 {
+			continue
+		}
+		operator, err := strconv.Atoi(strings.TrimSpace(item[:index]))
+		if err != nil {
+			panic("OperatorTimezone配置错误:" + item)
+		}
+		result[operator] = strings.TrimSpace(item[index+1:])
+	}
+	return result
+}
+
+// 时区下的日期，与数据库中的日期一致使用UTC零点表示
+func dayIn(t time.Time, location *time.Location) time.Time {
+	year, month, day := t.In(location).Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// 数数#zone_offset,单位小时
+func zoneOffset(t time.Time) float64 {
+	_, offset := t.Zone()
+	return float64(offset) / 3600
+}
+
+func locationOrLocal(location *time.Location) *time.Location {
+	if location == nil {
+		return time.Local
+	}
+	return location
+}
@@ -0,0 +1,82 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"xai.com/shushu/app/model"
+)
+
+func TestDayIn(t *testing.T) {
+	shanghai, _ := loadLocation("Asia/Shanghai")
+	losAngeles, _ := loadLocation("America/Los_Angeles")
+	// UTC 2021-05-02 17:00 为上海5月3日凌晨,洛杉矶5月2日上午
+	instant := time.Date(2021, 5, 2, 17, 0, 0, 0, time.UTC)
+	if dayIn(instant, shanghai) != time.Date(2021, 5, 3, 0, 0, 0, 0, time.UTC) {
+		t.Fatal("上海日期错误", dayIn(instant, shanghai))
+	}
+	if dayIn(instant, losAngeles) != time.Date(2021, 5, 2, 0, 0, 0, 0, time.UTC) {
+		t.Fatal("洛杉矶日期错误", dayIn(instant, losAngeles))
+	}
+	if zoneOffset(instant.In(shanghai)) != 8 {
+		t.Fatal("时区偏移错误", zoneOffset(instant.In(shanghai)))
+	}
+}
+
+func TestServerLocation(t *testing.T) {
+	appConfig := &model.AppConfig{DefaultTimezone: "UTC", OperatorTimezone: "1=Asia/Shanghai;2=America/Los_Angeles"}
+	cases := []struct {
+		server *model.ServerConfig
+		expect string
+	}{
+		{&model.ServerConfig{Operator: 1, Server: 1}, "Asia/Shanghai"},
+		{&model.ServerConfig{Operator: 1, Server: 2, Timezone: "Asia/Tokyo"}, "Asia/Tokyo"},
+		{&model.ServerConfig{Operator: 3, Server: 1}, "UTC"},
+	}
+	for _, c := range cases {
+		if location := serverLocation(appConfig, c.server); location.String() != c.expect {
+			t.Fatal(c.server, "时区错误", location)
+		}
+	}
+}
+
+func TestParseTimeInServerZone(t *testing.T) {
+	shanghai, _ := loadLocation("Asia/Shanghai")
+	field := &model.Field{Index: 1, DataType: "date"}
+	_ = compileFieldType(field, nil)
+	eventConfig := &model.EventConfig{Name: "test", UploadType: model.UploadTrack, Fields: map[string]*model.Field{"#time": field}}
+	rows := decodeLines("tsv", []string{"1620000000000"}, nil)
+	values := parse(eventConfig, &LogSource{Location: shanghai}, rows[0])
+	if values.String() != `{"#time":"2021-05-03 08:00:00.000","#type":"track","#zone_offset":8}` {
+		t.Fatal("#time时区错误", values)
+	}
+}
+
+func TestLoadServerConfigTimezone(t *testing.T) {
+	dir, err := ioutil.TempDir("", "serverlist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	path := filepath.Join(dir, "serverlist")
+	if err = ioutil.WriteFile(path, []byte("1 1 8001 Asia/Tokyo\n1 2 8002\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config := LoadServerConfig(path)
+	if len(config) != 2 || config["11"].Timezone != "Asia/Tokyo" || config["12"].Timezone != "" {
+		t.Fatal("serverlist时区解析错误", config)
+	}
+
+	// 时区错误不能忽视此行，否则该服务器的日志不会上报
+	if err = ioutil.WriteFile(path, []byte("1 1 8001 Asia/Tokyo\n1 2 8002 Asia/Nowhere\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatal("时区配置错误应该失败")
+		}
+	}()
+	LoadServerConfig(path)
+}
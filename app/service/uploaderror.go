@@ -0,0 +1,168 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"xai.com/shushu/app/model"
+)
+
+// 永久失败保留断点，下一轮重新上报，与之前的处理方式相同
+const policyBlock = "block"
+
+const (
+	maxRetryInterval = time.Minute      // 指数退避的最长间隔
+	maxRetryAfter    = 10 * time.Minute // Retry-After的最长等待时间
+)
+
+// 上报失败原因，permanent表示重试也不会成功(appid不存在,数据格式错误等)
+type uploadError struct {
+	permanent  bool
+	reason     string
+	retryAfter time.Duration // 服务端要求的等待时间
+	message    string
+}
+
+func (e *uploadError) Error() string {
+	return e.message
+}
+
+func retryableError(reason string, format string, args ...interface{}) *uploadError {
+	return &uploadError{reason: reason, message: fmt.Sprintf(format, args...)}
+}
+
+func permanentError(reason string, format string, args ...interface{}) *uploadError {
+	return &uploadError{permanent: true, reason: reason, message: fmt.Sprintf(format, args...)}
+}
+
+// 是否为重试也不会成功的错误
+func isPermanent(err error) bool {
+	var e *uploadError
+	return errors.As(err, &e) && e.permanent
+}
+
+// 按照http状态码分类，5xx,408,429可以重试，其他4xx重试也不会成功
+func statusError(res *http.Response) *uploadError {
+	code := res.StatusCode
+	if code >= 500 || code == http.StatusRequestTimeout || code == http.StatusTooManyRequests {
+		e := retryableError("http_"+strconv.Itoa(code), "上报数据失败 %s", res.Status)
+		e.retryAfter = parseRetryAfter(res.Header.Get("Retry-After"), time.Now())
+		return e
+	}
+	return permanentError("http_"+strconv.Itoa(code), "上报数据失败 %s", res.Status)
+}
+
+// 按照数数返回码分类，未知的返回码按照可以重试处理
+func responseError(res *model.ShuShuHttpRes) *uploadError {
+	switch res.Code {
+	case -1:
+		return permanentError("invalid_data", "数数上报异常 %s invalid data format", res.Msg)
+	case -2:
+		return permanentError("invalid_appid", "数数上报异常 %s APP ID doesn't exist", res.Msg)
+	case -3:
+		return permanentError("invalid_ip", "数数上报异常 %s invalid ip transmission", res.Msg)
+	}
+	return retryableError("code_"+strconv.Itoa(res.Code), "Unexpected response return code %d %s", res.Code, res.Msg)
+}
+
+// Retry-After支持秒数和http时间两种格式，无法解析时返回0
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if len(value) == 0 {
+		return 0
+	}
+	var wait time.Duration
+	if seconds, err := strconv.Atoi(value); err == nil {
+		wait = time.Duration(seconds) * time.Second
+	} else if at, err := http.ParseTime(value); err == nil {
+		wait = at.Sub(now)
+	}
+	if wait < 0 {
+		return 0
+	}
+	if wait > maxRetryAfter {
+		return maxRetryAfter
+	}
+	return wait
+}
+
+var jitter = struct {
+	sync.Mutex
+	random *rand.Rand
+}{random: rand.New(rand.NewSource(time.Now().UnixNano()))}
+
+// 第attempt次失败后的等待时间，间隔按照指数增长并且随机抖动，避免多个任务同时重试。
+// 服务端返回Retry-After时至少等待Retry-After
+func retryDelay(interval time.Duration, attempt int, err error) time.Duration {
+	delay := interval
+	for i := 1; i < attempt && delay < maxRetryInterval; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryInterval && interval < maxRetryInterval {
+		delay = maxRetryInterval
+	}
+	// 在[delay/2, delay]范围内随机
+	if half := int64(delay / 2); half > 0 {
+		jitter.Lock()
+		delay = time.Duration(half + jitter.random.Int63n(half+1))
+		jitter.Unlock()
+	}
+	var e *uploadError
+	if errors.As(err, &e) && e.retryAfter > delay {
+		delay = e.retryAfter
+	}
+	return delay
+}
+
+// 上报永久失败的处理策略
+var uploadFailurePolicy = policyBlock
+
+// 初始化上报永久失败的处理策略
+func initUploadFailurePolicy(config *model.AppConfig) {
+	policy := strings.TrimSpace(config.UploadFailurePolicy)
+	if len(policy) == 0 {
+		policy = policyBlock
+	}
+	switch policy {
+	case policyBlock, policyDrop:
+	case policyDeadLetter:
+		if !deadLetterEnabled() {
+			panic("UploadFailurePolicy为deadletter时必须配置DeadLetterPath")
+		}
+	default:
+		panic("UploadFailurePolicy配置错误,不支持的处理策略[" + policy + "]")
+	}
+	uploadFailurePolicy = policy
+}
+
+// 处理永久失败的数据，返回nil时当前批次认为已经处理，可以保存断点
+func handlePermanentFailure(target *destination, rows []*uploadRow, err error) error {
+	addMetric(uploadMetrics, target.name+"_permanent_"+uploadFailurePolicy, int64(len(rows)))
+	switch uploadFailurePolicy {
+	case policyDrop:
+		log.Println(target, "上报永久失败,丢弃数据", len(rows), err)
+		return nil
+	case policyDeadLetter:
+		reason := "upload"
+		var e *uploadError
+		if errors.As(err, &e) {
+			reason += "_" + e.reason
+		}
+		for _, row := range rows {
+			record := &deadLetterRecord{Reason: reason, Target: target.name, Data: row.String()}
+			if name, ok := row.fields.get("#event_name"); ok {
+				record.Event, _ = name.(string)
+			}
+			writeDeadLetter(record)
+		}
+		log.Println(target, "上报永久失败,写入死信文件", len(rows), err)
+		return nil
+	}
+	return fmt.Errorf("%s上报永久失败,等待下一轮重新上报:%v", target, err)
+}
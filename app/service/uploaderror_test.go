@@ -0,0 +1,223 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+	"xai.com/shushu/app/model"
+)
+
+func TestHttpPostErrors(t *testing.T) {
+	var status int32
+	var response atomic.Value
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = ioutil.ReadAll(r.Body)
+		if code := int(atomic.LoadInt32(&status)); code != 200 {
+			w.Header().Set("Retry-After", "7")
+			w.WriteHeader(code)
+			return
+		}
+		_, _ = w.Write([]byte(response.Load().(string)))
+	}))
+	defer server.Close()
+	httpClient = server.Client()
+	body, _ := encodeRows([]*uploadRow{testUploadRow(map[string]interface{}{"#type": "track"}, nil)}, nil)
+	defer body.release()
+	target := &destination{name: "classify", url: server.URL, appId: "a"}
+
+	cases := []struct {
+		status     int
+		response   string
+		permanent  bool
+		reason     string
+		retryAfter time.Duration
+	}{
+		{200, `{"code":-1}`, true, "invalid_data", 0},
+		{200, `{"code":-2}`, true, "invalid_appid", 0},
+		{200, `{"code":-3}`, true, "invalid_ip", 0},
+		{200, `{"code":-9}`, false, "code_-9", 0},
+		{200, `<html>bad gateway</html>`, false, "response", 0},
+		{400, "", true, "http_400", 0},
+		{403, "", true, "http_403", 0},
+		{429, "", false, "http_429", 7 * time.Second},
+		{503, "", false, "http_503", 7 * time.Second},
+	}
+	for _, c := range cases {
+		atomic.StoreInt32(&status, int32(c.status))
+		response.Store(c.response)
+		err := httpPost(context.Background(), target, body)
+		var e *uploadError
+		if !errors.As(err, &e) || e.permanent != c.permanent || e.reason != c.reason || e.retryAfter != c.retryAfter || isPermanent(err) != c.permanent {
+			t.Fatal(c.status, c.response, "失败分类错误", err)
+		}
+	}
+	response.Store(`{"code":0}`)
+	atomic.StoreInt32(&status, 200)
+	if err := httpPost(context.Background(), target, body); err != nil {
+		t.Fatal(err)
+	}
+
+	// 网络异常可以重试
+	server.Close()
+	if err := httpPost(context.Background(), target, body); err == nil || isPermanent(err) {
+		t.Fatal("网络异常应该可以重试", err)
+	}
+}
+
+// 写入失败的压缩writer
+type failingWriter struct{}
+
+func (w *failingWriter) Write(p []byte) (int, error) { return 0, errors.New("compress") }
+func (w *failingWriter) Close() error                { return nil }
+func (w *failingWriter) Reset(io.Writer)             {}
+
+func TestEncodeFailure(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		_, _ = w.Write([]byte(`{"code":0}`))
+	}))
+	defer server.Close()
+	httpClient = server.Client()
+	failing := &compressor{spec: "failing", name: "failing"}
+	failing.writers.New = func() interface{} { return &failingWriter{} }
+	target := &destination{name: "encode", url: server.URL, appId: "a", retryTimes: 3, compressor: failing}
+	err := uploadRows(context.Background(), target, []*uploadRow{testUploadRow(map[string]interface{}{"#type": "track"}, nil)})
+	var e *uploadError
+	if !errors.As(err, &e) || !e.permanent || e.reason != "encode" || requests != 0 {
+		t.Fatal("编码失败应该是永久失败并且不上报", requests, err)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	for attempt := 1; attempt <= 10; attempt++ {
+		delay := retryDelay(time.Second, attempt, errors.New("network"))
+		max := time.Second << uint(attempt-1)
+		if max > maxRetryInterval {
+			max = maxRetryInterval
+		}
+		if delay < max/2 || delay > max {
+			t.Fatal(attempt, "退避时间错误", delay)
+		}
+	}
+	if delay := retryDelay(2*time.Minute, 3, nil); delay < time.Minute || delay > 2*time.Minute {
+		t.Fatal("超过最长间隔的配置应该保留", delay)
+	}
+	if delay := retryDelay(0, 3, nil); delay != 0 {
+		t.Fatal("没有配置间隔时不等待", delay)
+	}
+	if delay := retryDelay(time.Second, 1, &uploadError{retryAfter: 30 * time.Second}); delay != 30*time.Second {
+		t.Fatal("应该等待Retry-After", delay)
+	}
+
+	now := time.Date(2021, 5, 2, 12, 0, 0, 0, time.UTC)
+	if wait := parseRetryAfter("120", now); wait != 2*time.Minute {
+		t.Fatal("Retry-After秒数解析错误", wait)
+	}
+	if wait := parseRetryAfter(now.Add(90*time.Second).Format(http.TimeFormat), now); wait != 90*time.Second {
+		t.Fatal("Retry-After时间解析错误", wait)
+	}
+	for _, value := range []string{"", "abc", "-5", now.Add(-time.Hour).Format(http.TimeFormat)} {
+		if wait := parseRetryAfter(value, now); wait != 0 {
+			t.Fatal(value, "无效的Retry-After应该忽视", wait)
+		}
+	}
+	if wait := parseRetryAfter("86400", now); wait != maxRetryAfter {
+		t.Fatal("Retry-After超过上限", wait)
+	}
+}
+
+func TestUploadFailurePolicy(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		_, _ = w.Write([]byte(`{"code":-2,"msg":"appid"}`))
+	}))
+	defer server.Close()
+	httpClient = server.Client()
+	dir, err := ioutil.TempDir("", "deadletter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	path := filepath.Join(dir, "deadletter.log")
+	initDeadLetter(path)
+	defer initDeadLetter("")
+	defer initUploadFailurePolicy(&model.AppConfig{})
+
+	target := &destination{name: "permanent", url: server.URL, appId: "a", retryTimes: 5, retryInterval: time.Hour}
+	if err := uploadRows(context.Background(), target, []*uploadRow{testUploadRow(map[string]interface{}{"#type": "track"}, nil)}); !isPermanent(err) || requests != 1 {
+		t.Fatal("永久失败不应该重试", requests, err)
+	}
+
+	eventTime := strconv.FormatInt(time.Now().Add(-time.Hour).UnixNano()/int64(time.Millisecond), 10)
+	lines := []string{"acc_1\t" + eventTime, "acc_2\t" + eventTime, "acc_3"}
+	eventConfigs := []*model.EventConfig{testEventConfig("login")}
+	sinkTarget := &sink{name: "permanent", router: &router{defaultDestination: target}}
+	dropped := getMetric(uploadMetrics, "permanent_permanent_drop")
+	deadLetters := getMetric(uploadMetrics, "permanent_permanent_deadletter")
+	for _, policy := range []string{"", policyBlock, policyDrop, policyDeadLetter} {
+		initUploadFailurePolicy(&model.AppConfig{UploadFailurePolicy: policy})
+		source := &LogSource{Path: "1_1_LoginRecord.2021-05-02", Stats: newBatchStats()}
+		err := httpProcess(context.Background(), sinkTarget, source, lines, eventConfigs)
+		blocked := policy == "" || policy == policyBlock
+		if (err != nil) != blocked {
+			t.Fatal(policy, "永久失败处理错误", err)
+		}
+		// 被拒绝的行计入忽视，不能计入上报
+		if !blocked && (source.Stats.Acked != 0 || source.Stats.Dropped != 3 || source.Stats.Sent["login"] != 0) {
+			t.Fatal(policy, "永久失败的行统计错误", source.Stats)
+		}
+	}
+	if getMetric(uploadMetrics, "permanent_permanent_drop")-dropped != 2 || getMetric(uploadMetrics, "permanent_permanent_deadletter")-deadLetters != 2 {
+		t.Fatal("永久失败指标错误")
+	}
+
+	initDeadLetter(path)
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if len(records) != 2 {
+		t.Fatal("死信行数错误", records)
+	}
+	record := &deadLetterRecord{}
+	if err = json.Unmarshal([]byte(records[0]), record); err != nil {
+		t.Fatal(err)
+	}
+	if record.Reason != "upload_invalid_appid" || record.Target != "permanent" || record.Event != "login" || !strings.Contains(record.Data, `"#account_id":"acc_1"`) {
+		t.Fatal("死信内容错误", record)
+	}
+
+	for _, policy := range []string{"retry", policyClamp} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatal(policy, "应该校验失败")
+				}
+			}()
+			initUploadFailurePolicy(&model.AppConfig{UploadFailurePolicy: policy})
+		}()
+	}
+	initDeadLetter("")
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("deadletter必须配置死信文件")
+			}
+		}()
+		initUploadFailurePolicy(&model.AppConfig{UploadFailurePolicy: policyDeadLetter})
+	}()
+}
@@ -1,6 +1,9 @@
+VERSION=$(git describe --tags --always 2>/dev/null || echo dev)
+LDFLAGS="-X xai.com/shushu/app/service.Version=${VERSION}"
+# sqlite断点数据库需要cgo，交叉编译时没有C编译器，mac和windows版本只支持mysql,postgres
 echo "开始编译linux"
-GOOS=linux GOARCH=amd64 go build -o bin/shushu-linux
-echo "开始编译mac"
-GOOS=darwin GOARCH=amd64 go build -o bin/shushu-mac
-echo "开始编译windows"
-GOOS=windows GOARCH=amd64 go build -o bin/shushu-windows.exe
+CGO_ENABLED=1 GOOS=linux GOARCH=amd64 go build -ldflags "${LDFLAGS}" -o bin/shushu-linux || exit 1
+echo "开始编译mac(不支持sqlite)"
+CGO_ENABLED=0 GOOS=darwin GOARCH=amd64 go build -ldflags "${LDFLAGS}" -o bin/shushu-mac || exit 1
+echo "开始编译windows(不支持sqlite)"
+CGO_ENABLED=0 GOOS=windows GOARCH=amd64 go build -ldflags "${LDFLAGS}" -o bin/shushu-windows.exe || exit 1
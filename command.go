@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"xai.com/shushu/app/model"
+	"xai.com/shushu/app/service"
+)
+
+// 命令行子命令，返回false表示不是子命令，按照常驻进程启动
+func runCommand(appConfig *model.AppConfig, args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	switch args[0] {
+	case "ledger":
+		ledgerCommand(appConfig, args[1:])
+		return true
+	case "replay":
+		replayCommand(appConfig, args[1:])
+		return true
+	}
+	return false
+}
+
+// 查询日志文件上报记录，如 ledger -server 3_12 -log ItemRecord -day 2021-05-02
+func ledgerCommand(appConfig *model.AppConfig, args []string) {
+	flags := flag.NewFlagSet("ledger", flag.ExitOnError)
+	operator := flags.Int("operator", 0, "运营商")
+	server := flags.String("server", "", "服务器,格式 12 或者 运营商_服务器(3_12)")
+	recordName := flags.String("log", "", "日志名称")
+	sink := flags.String("sink", "", "输出目标")
+	day := flags.String("day", "", "日志日期,格式2021-05-02")
+	completed := flags.String("completed", "", "是否上报完成true,false")
+	mismatch := flags.Bool("mismatch", false, "只查询对账不一致的记录")
+	limit := flags.Int("limit", 100, "最大数量")
+	asJson := flags.Bool("json", false, "按照json输出")
+	_ = flags.Parse(args)
+
+	filter := service.LedgerFilter{Operator: *operator, Log: *recordName, Sink: *sink, Day: *day, Completed: *completed, Mismatch: *mismatch, Limit: *limit}
+	if len(*server) > 0 {
+		serverStr := *server
+		if index := strings.Index(serverStr, "_"); index > 0 {
+			op, err := strconv.Atoi(serverStr[:index])
+			if err != nil {
+				log.Fatalln("服务器参数错误", serverStr)
+			}
+			filter.Operator, serverStr = op, serverStr[index+1:]
+		}
+		serverId, err := strconv.Atoi(serverStr)
+		if err != nil {
+			log.Fatalln("服务器参数错误", *server)
+		}
+		filter.Server = serverId
+	}
+	service.InitDatabase(appConfig)
+	entries, err := service.QueryLedger(context.Background(), filter)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if *asJson {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		_ = encoder.Encode(entries)
+		return
+	}
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(writer, "文件\t日期\t输出目标\t完成\t大小\t读取位置\t行数\t上报\t过滤\t忽视\t首次上报\t最后上报\t对账")
+	for _, entry := range entries {
+		events := make([]string, 0, len(entry.Sent))
+		for event, rows := range entry.Sent {
+			events = append(events, event+"="+strconv.FormatInt(rows, 10))
+		}
+		sort.Strings(events)
+		_, _ = fmt.Fprintf(writer, "%d_%d_%s\t%s\t%s\t%t\t%d\t%d\t%d\t%s\t%d\t%d\t%s\t%s\t%s\n",
+			entry.Operator, entry.Server, entry.Log, entry.Day, entry.Sink, entry.Completed, entry.Size, entry.Position,
+			entry.Lines, strings.Join(events, ","), entry.Filtered, entry.Dropped, entry.FirstUpload, entry.LastUpload, entry.Reconcile)
+	}
+	_ = writer.Flush()
+}
+
+// 重新上报历史日志，如 replay -job fix0502 -from 2021-05-02 -to 2021-05-03 -server 1-100 -log ItemRecord -sink backup
+// 进度单独记录，不影响常驻进程的断点，中断后使用相同的job继续
+func replayCommand(appConfig *model.AppConfig, args []string) {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	job := flags.String("job", "", "任务名,相同任务名从上次的进度继续")
+	from := flags.String("from", "", "开始日期,格式2021-05-02")
+	to := flags.String("to", "", "结束日期(包含),为空时只上报开始日期")
+	operators := flags.String("operator", "", "运营商,逗号分隔,为空时所有运营商")
+	servers := flags.String("server", "", "服务器范围,格式1-100,200,为空时所有服务器")
+	records := flags.String("log", "", "日志名称,逗号分隔,为空时所有日志")
+	sink := flags.String("sink", "", "输出目标,为空时使用默认配置")
+	rate := flags.Int("rate", 0, "每秒最多上报行数,0不限制")
+	pastTolerance := flags.String("past-tolerance", "", "#time早于当前时间的容忍范围,如720h,为空时不检查(不使用PastTolerance配置)")
+	pastPolicy := flags.String("past-policy", "drop", "超出过期容忍范围的处理策略(drop,clamp,send,deadletter)")
+	_ = flags.Parse(args)
+
+	options, err := service.ParseReplayOptions(*job, *from, *to, *operators, *servers, *records, *sink, *rate)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	eventConfigByRecordName := classifyByRecordName(service.LoadConfig(appConfig))
+	recordTypes := make(map[string]string)
+	for recordName, eventConfigs := range eventConfigByRecordName {
+		if len(eventConfigs) > 0 {
+			recordTypes[recordName] = eventConfigs[0].FileType
+		}
+	}
+	serverConfigs := service.LoadServerConfig(appConfig.ServerList)
+	service.InitDatabase(appConfig)
+	service.InitConsumer(appConfig)
+	if err := options.SetPastPolicy(*pastTolerance, *pastPolicy); err != nil {
+		log.Fatalln(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		log.Println("收到信号,停止重新上报", sig.String())
+		cancel()
+	}()
+	result, err := service.Replay(ctx, appConfig, serverConfigs, recordTypes, options, func(ctx context.Context, source *service.LogSource, lines []string) error {
+		return service.Process(ctx, source, lines, eventConfigByRecordName[source.RecordName])
+	})
+	if result != nil {
+		log.Println("重新上报文件", result.Files, "跳过已完成文件", result.Skipped, "行数", result.Lines)
+	}
+	if err != nil {
+		log.Fatalln("重新上报中断,使用相同的job继续", err)
+	}
+}
@@ -5,5 +5,10 @@ go 1.14
 require (
 	github.com/360EntSecGroup-Skylar/excelize v1.4.1
 	github.com/go-sql-driver/mysql v1.6.0
+	github.com/klauspost/compress v1.13.6
+	github.com/lib/pq v1.10.9
+	github.com/mattn/go-sqlite3 v1.14.16
 	github.com/mitchellh/mapstructure v1.4.1
+	github.com/pierrec/lz4/v4 v4.1.17
+	golang.org/x/text v0.3.8
 )
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
 	_ "net/http/pprof"
@@ -16,24 +17,34 @@ import (
 func main() {
 	// 系统配置
 	appConfig := service.LoadAppConfig("config/application.properties")
-	// 开启线上状态监控
+	// 命令行子命令
+	if runCommand(appConfig, os.Args[1:]) {
+		return
+	}
+	// 启动前校验停止等待时间，避免收到停止信号时才发现配置错误
+	drainTimeout := shutdownTimeout(appConfig)
+	// 开启线上状态监控和管理接口
 	if len(appConfig.StartPprof) > 0 {
+		http.HandleFunc("/admin/ledger", service.LedgerHandler)
 		go func() { _ = http.ListenAndServe(appConfig.StartPprof, nil) }()
 	}
 	// 日志类型配置
-	eventConfigs := service.LoadConfig(appConfig.ExcelPath)
+	eventConfigs := service.LoadConfig(appConfig)
 	// 按照日志名分类
 	eventConfigByRecordName := classifyByRecordName(eventConfigs)
 	// 服务器列表
 	serverConfigs := service.LoadServerConfig(appConfig.ServerList)
 
 	// 初始化数据库
-	service.InitDatabase(appConfig.MysqlUser, appConfig.MysqlPassword, appConfig.MysqlAddr, appConfig.MysqlDatabase)
+	service.InitDatabase(appConfig)
 
-	// 注册扫描任务
-	registerEvent(serverConfigs, eventConfigByRecordName, appConfig)
-	// 初始化处理每行内容处理器
+	// 断点写入方式
+	ctx, cancel := context.WithCancel(context.Background())
+	service.InitCheckpoint(ctx, appConfig)
+	// 初始化处理每行内容处理器和输出目标
 	service.InitConsumer(appConfig)
+	// 注册扫描任务，每个输出目标一个任务
+	registerEvent(serverConfigs, eventConfigByRecordName, appConfig)
 	log.Println("开始扫描任务")
 	interval, _ := strconv.Atoi(appConfig.LogProcessInterval)
 	processInterval := time.Duration(interval) * time.Second
@@ -41,9 +52,10 @@ func main() {
 	signals := make(chan os.Signal, 1)
 	signal.Notify(signals, os.Interrupt, os.Kill, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGTERM)
 	// 开始扫描任务
-	service.StartScanLog(processInterval, func(recordName string, lines []string) {
-		eventConfigs := eventConfigByRecordName[recordName]
-		service.Process(recordName, lines, eventConfigs)
+	// 上报不随调度停止，Shutdown超时后才放弃
+	service.StartScanLog(ctx, context.Background(), processInterval, func(ctx context.Context, source *service.LogSource, lines []string) error {
+		eventConfigs := eventConfigByRecordName[source.RecordName]
+		return service.Process(ctx, source, lines, eventConfigs)
 	})
 	// 定时读取serverlist文件，运维会动态修改此文件
 	if len(appConfig.ServerListReRead) > 0 {
@@ -61,10 +73,30 @@ func main() {
 			}()
 		}
 	}
+	awaitShutdown(signals, cancel, drainTimeout)
+}
+
+// 停止时等待正在上报的数据完成的最长时间，默认30s
+func shutdownTimeout(appConfig *model.AppConfig) time.Duration {
+	if len(appConfig.ShutdownTimeout) == 0 {
+		return 30 * time.Second
+	}
+	duration, err := time.ParseDuration(appConfig.ShutdownTimeout)
+	if err != nil || duration < 0 {
+		log.Panic("ShutdownTimeout配置错误", appConfig.ShutdownTimeout, err)
+	}
+	return duration
+}
+
+// 收到停止信号后停止调度，等待正在上报的数据完成，超时后以退出码1退出
+func awaitShutdown(signals <-chan os.Signal, cancel context.CancelFunc, drainTimeout time.Duration) {
 	sig := <-signals
 	log.Println("收到信号,准备关闭所有任务", sig.String())
-	service.StopScanLog()
-	time.Sleep(2 * time.Second)
+	cancel()
+	if !service.Shutdown(drainTimeout) {
+		log.Println("进程停止,有数据没有上报确认,下次启动从断点重新上报")
+		os.Exit(1)
+	}
 	log.Println("进程已经正确停止")
 }
 